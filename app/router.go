@@ -4,6 +4,7 @@ import (
 	"akmmp241/dinamcom-2024/dinacom-go-rest/controllers"
 	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
 	"akmmp241/dinamcom-2024/dinacom-go-rest/middleware"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
 	"github.com/gofiber/fiber/v2"
 	"time"
 )
@@ -13,6 +14,13 @@ func NewRouter(
 	authController controllers.AuthController,
 	complaintController controllers.ComplaintController,
 	drugController controllers.DrugController,
+	twoFactorController controllers.TwoFactorController,
+	accountDeletionController controllers.AccountDeletionController,
+	profileController controllers.ProfileController,
+	roleController controllers.RoleController,
+	apiKeyController controllers.ApiKeyController,
+	auditController controllers.AuditController,
+	impersonationController controllers.ImpersonationController,
 ) *fiber.App {
 	appRouter := fiber.New(fiber.Config{
 		Prefork:      true,
@@ -26,22 +34,73 @@ func NewRouter(
 	auth := api.Group("/auth")
 	auth.Post("/register", authController.Register)
 	auth.Post("/login", authController.Login)
-	auth.Post("/google/callback", authController.GoogleCallback)
+	auth.Post("/login/2fa", authController.LoginTwoFactor)
+	auth.Post("/magic-link", middleware.SendMagicLinkMailRateLimiter, authController.SendMagicLink)
+	auth.Post("/magic-link/verify", authController.MagicLinkLogin)
+	auth.Get("/unlock", authController.UnlockAccount)
+	auth.Post("/devices/report", authController.ReportDevice)
+	auth.Get("/identities", middleware.Authenticate, authController.GetIdentities)
+	auth.Post("/identities/:provider", middleware.Authenticate, authController.LinkIdentity)
+	auth.Delete("/identities/:provider", middleware.Authenticate, authController.UnlinkIdentity)
 	auth.Get("/me", authController.Me)
+	auth.Delete("/me", middleware.Authenticate, accountDeletionController.RequestDeletion)
+	auth.Get("/deletions/:receiptId", accountDeletionController.GetReceipt)
 	auth.Post("/forget/password", middleware.SendOtpMailRateLimiter, authController.ForgetPassword)
 	auth.Post("/forget/password/verify", authController.VerifyForgetPasswordOtp)
 	auth.Post("/reset/password", authController.ResetPassword)
+	auth.Put("/password", middleware.Authenticate, authController.ChangePassword)
+	auth.Get("/email/verify", authController.VerifyEmail)
+	auth.Post("/email/verify/resend", middleware.Authenticate, middleware.SendVerificationMailRateLimiter, authController.ResendVerificationEmail)
+	auth.Post("/refresh", authController.Refresh)
+	auth.Post("/logout", middleware.Authenticate, authController.Logout)
+	auth.Post("/logout-all", middleware.Authenticate, authController.LogoutAll)
+	auth.Get("/sessions", middleware.Authenticate, authController.GetSessions)
+	auth.Delete("/sessions/:sessionId", middleware.Authenticate, authController.RevokeSession)
+	auth.Get("/security-events", middleware.Authenticate, auditController.GetMine)
+	auth.Get("/:provider/login", authController.OauthLogin)
+	auth.Get("/:provider/callback", authController.OauthCallback)
+
+	twoFactor := auth.Group("/2fa")
+	twoFactor.Use(middleware.Authenticate)
+	twoFactor.Post("/enroll", twoFactorController.Enroll)
+	twoFactor.Post("/confirm", twoFactorController.Confirm)
+	twoFactor.Post("/disable", twoFactorController.Disable)
+
+	profile := api.Group("/profile")
+	profile.Use(middleware.Authenticate)
+	profile.Get("/", profileController.Get)
+	profile.Put("/", profileController.Update)
+	profile.Delete("/", profileController.Delete)
+
+	apiKeys := api.Group("/api-keys")
+	apiKeys.Use(middleware.Authenticate, middleware.RequirePermission(model.PermissionManageApiKeys))
+	apiKeys.Get("/", apiKeyController.GetAll)
+	apiKeys.Post("/", apiKeyController.Create)
+	apiKeys.Delete("/:apiKeyId", apiKeyController.Revoke)
+
+	complaintRead := middleware.AuthenticateOrApiKey(model.ScopeComplaintsRead)
+	complaintWrite := middleware.AuthenticateOrApiKey(model.ScopeComplaintsWrite)
 
 	complaint := api.Group("/complaints")
-	complaint.Use(middleware.Authenticate)
-	complaint.Post("/", complaintController.ExternalWound)
-	complaint.Get("/", complaintController.GetAll)
-	complaint.Post("/simplify", complaintController.Simplifier)
-	complaint.Get("/:complaintId", complaintController.GetById)
-	complaint.Put("/:complaintId", complaintController.Update)
-	complaint.Get("/:complaintId/recommendations", complaintController.GetRecommendedDrugs)
+	complaint.Post("/", complaintWrite, complaintController.ExternalWound)
+	complaint.Get("/", complaintRead, complaintController.GetAll)
+	complaint.Post("/simplify", complaintWrite, complaintController.Simplifier)
+	complaint.Get("/:complaintId", complaintRead, complaintController.GetById)
+	complaint.Put("/:complaintId", complaintWrite, complaintController.Update)
+	complaint.Get("/:complaintId/recommendations", complaintRead, complaintController.GetRecommendedDrugs)
 
 	api.Get("/drugs/:drugId", drugController.GetById)
 
+	manageRoles := middleware.RequirePermission(model.PermissionManageRoles)
+
+	admin := api.Group("/admin")
+	admin.Use(middleware.Authenticate)
+	admin.Get("/roles", manageRoles, roleController.GetRoles)
+	admin.Get("/users/:userId/roles", manageRoles, roleController.GetUserRoles)
+	admin.Post("/users/:userId/roles", manageRoles, roleController.AssignRole)
+	admin.Delete("/users/:userId/roles/:role", manageRoles, roleController.RevokeRole)
+	admin.Get("/audit-events", middleware.RequirePermission(model.PermissionReadAudit), auditController.Search)
+	admin.Post("/users/:userId/impersonate", middleware.RequirePermission(model.PermissionImpersonateUsers), impersonationController.Impersonate)
+
 	return appRouter
 }
@@ -14,6 +14,7 @@ func NewConfig() *Config {
 	config.SetConfigFile(".env")
 	config.AddConfigPath(".")
 	config.AutomaticEnv()
+	setDefaults(config)
 
 	err := config.ReadInConfig()
 	if err != nil {
@@ -22,3 +23,60 @@ func NewConfig() *Config {
 
 	return &Config{Env: config}
 }
+
+func setDefaults(config *viper.Viper) {
+	config.SetDefault("APP_KEY_ID", "default")
+	config.SetDefault("APP_KEY_GRACE_PERIOD", "168h")
+	config.SetDefault("ACCESS_TOKEN_TTL", "15m")
+	config.SetDefault("REFRESH_TOKEN_TTL", "168h")
+	config.SetDefault("SESSION_CACHE_TTL", "5m")
+	config.SetDefault("SESSION_RENEW_INTERVAL", "1h")
+	config.SetDefault("SESSION_IDLE_TIMEOUT", "72h")
+	config.SetDefault("SESSION_MAX_LIFETIME", "720h")
+	config.SetDefault("APP_URL", "http://localhost:3000")
+	config.SetDefault("TOTP_ISSUER", "Evia")
+	config.SetDefault("MAGIC_LINK_TTL", "15m")
+	config.SetDefault("MAGIC_LINK_URL", "http://localhost:3000/magic-link")
+	config.SetDefault("NEW_DEVICE_REPORT_TTL", "168h")
+	config.SetDefault("NEW_DEVICE_REPORT_URL", "http://localhost:3000/report-device")
+	config.SetDefault("PASSWORD_HASH_ALGORITHM", "argon2id")
+	config.SetDefault("PASSWORD_ARGON2_MEMORY", 19456)
+	config.SetDefault("PASSWORD_ARGON2_ITERATIONS", 2)
+	config.SetDefault("PASSWORD_ARGON2_PARALLELISM", 1)
+	config.SetDefault("PASSWORD_BCRYPT_COST", 10)
+	config.SetDefault("PASSWORD_MIN_LENGTH", 8)
+	config.SetDefault("PASSWORD_REQUIRE_LOWERCASE", true)
+	config.SetDefault("PASSWORD_REQUIRE_UPPERCASE", true)
+	config.SetDefault("PASSWORD_REQUIRE_DIGIT", true)
+	config.SetDefault("PASSWORD_REQUIRE_SYMBOL", false)
+	config.SetDefault("PASSWORD_DISALLOW_EMAIL", true)
+	config.SetDefault("PASSWORD_BREACHED_MIN_COUNT", 1)
+	config.SetDefault("OTP_LENGTH", 6)
+	config.SetDefault("OTP_TTL", "5m")
+	config.SetDefault("OTP_MAX_ATTEMPTS", 5)
+	config.SetDefault("LOGIN_MAX_ATTEMPTS_PER_ACCOUNT", 5)
+	config.SetDefault("LOGIN_MAX_ATTEMPTS_PER_IP", 20)
+	config.SetDefault("LOGIN_ATTEMPT_WINDOW", "15m")
+	config.SetDefault("LOGIN_LOCKOUT_DURATION", "15m")
+	config.SetDefault("LOGIN_DELAY_BASE", "1s")
+	config.SetDefault("LOGIN_DELAY_MAX", "30s")
+	config.SetDefault("EMAIL_VERIFICATION_REQUIRED", true)
+	config.SetDefault("EMAIL_VERIFICATION_TOKEN_TTL", "24h")
+	config.SetDefault("EMAIL_VERIFICATION_ALLOWED_PATHS", "/api/auth")
+	config.SetDefault("OAUTH_HTTP_TIMEOUT", "5s")
+	config.SetDefault("OAUTH_STATE_TTL", "10m")
+	config.SetDefault("OIDC_DISCOVERY_TTL", "24h")
+	config.SetDefault("OAUTH_PROVIDERS", "google")
+	config.SetDefault("OAUTH_GOOGLE_ISSUER", "https://accounts.google.com")
+	config.SetDefault("OAUTH_GOOGLE_ALLOWED_ISSUERS", "https://accounts.google.com,accounts.google.com")
+	config.SetDefault("API_KEY_DEFAULT_TTL", "2160h")
+	config.SetDefault("API_KEY_MAX_TTL", "8760h")
+	config.SetDefault("API_KEY_MAX_PER_USER", 10)
+	config.SetDefault("IMPERSONATION_TTL", "30m")
+	config.SetDefault("IMPERSONATION_BLOCKED_PATHS", "/api/auth,/api/api-keys,/api/admin")
+	config.SetDefault("ACCOUNT_DELETION_RECENT_LOGIN", "5m")
+	config.SetDefault("TWO_FACTOR_RECENT_LOGIN", "5m")
+	config.SetDefault("ACCOUNT_DELETION_POLL_INTERVAL", "30s")
+	config.SetDefault("ACCOUNT_DELETION_STALE_AFTER", "15m")
+	config.SetDefault("ACCOUNT_DELETION_MAX_ATTEMPTS", 5)
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// Keyring holds the HMAC keys used to sign tokens. New tokens are always
+// signed with the current key, previous keys only verify tokens until the
+// grace period after the last rotation has passed.
+type Keyring struct {
+	CurrentKid   string
+	Keys         map[string][]byte
+	PreviousKids map[string]bool
+	RotatedAt    time.Time
+	GracePeriod  time.Duration
+}
+
+func NewKeyring(cnf *Config) *Keyring {
+	currentKid := cnf.Env.GetString("APP_KEY_ID")
+	currentKey := cnf.Env.GetString("APP_KEY")
+	if currentKey == "" {
+		log.Fatal("APP_KEY is required")
+	}
+
+	keyring := &Keyring{
+		CurrentKid:   currentKid,
+		Keys:         map[string][]byte{currentKid: []byte(currentKey)},
+		PreviousKids: map[string]bool{},
+		GracePeriod:  cnf.Env.GetDuration("APP_KEY_GRACE_PERIOD"),
+	}
+
+	// APP_PREVIOUS_KEYS is a comma separated list of kid=key pairs
+	for _, pair := range strings.Split(cnf.Env.GetString("APP_PREVIOUS_KEYS"), ",") {
+		kid, key, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || kid == "" || key == "" || kid == currentKid {
+			continue
+		}
+		keyring.Keys[kid] = []byte(key)
+		keyring.PreviousKids[kid] = true
+	}
+
+	// without the rotation time previous keys would expire at once, or if it
+	// defaulted to startup, never as long as the process restarts in time
+	rotatedAt := cnf.Env.GetString("APP_KEY_ROTATED_AT")
+	if rotatedAt == "" && len(keyring.PreviousKids) > 0 {
+		log.Fatal("APP_KEY_ROTATED_AT is required when APP_PREVIOUS_KEYS is set")
+	}
+	if rotatedAt != "" {
+		parsed, err := time.Parse(time.RFC3339, rotatedAt)
+		if err != nil {
+			log.Fatal("APP_KEY_ROTATED_AT must be an RFC3339 timestamp", err)
+		}
+		keyring.RotatedAt = parsed
+	}
+
+	return keyring
+}
+
+// Key returns the key for kid, or false when the kid is unknown or is a
+// previous key whose grace period has expired.
+func (k *Keyring) Key(kid string) ([]byte, bool) {
+	key, ok := k.Keys[kid]
+	if !ok {
+		return nil, false
+	}
+
+	if k.PreviousKids[kid] && time.Now().After(k.RotatedAt.Add(k.GracePeriod)) {
+		return nil, false
+	}
+
+	return key, true
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+	"testing"
+	"time"
+)
+
+func newTestKeyring(t *testing.T, values map[string]string) *Keyring {
+	t.Helper()
+
+	env := viper.New()
+	env.SetDefault("APP_KEY_ID", "default")
+	env.SetDefault("APP_KEY_GRACE_PERIOD", "168h")
+	for key, value := range values {
+		env.Set(key, value)
+	}
+
+	return NewKeyring(&Config{Env: env})
+}
+
+func TestKeyringWithoutRotation(t *testing.T) {
+	keyring := newTestKeyring(t, map[string]string{"APP_KEY": "current-key"})
+
+	key, ok := keyring.Key("default")
+	if !ok || string(key) != "current-key" {
+		t.Fatalf("Key(default) = %q, %v", key, ok)
+	}
+
+	if _, ok := keyring.Key("unknown"); ok {
+		t.Fatal("Key(unknown) found a key")
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	tests := []struct {
+		name         string
+		rotatedAt    time.Time
+		wantPrevious bool
+	}{
+		{name: "just rotated", rotatedAt: time.Now(), wantPrevious: true},
+		{name: "within grace period", rotatedAt: time.Now().Add(-167 * time.Hour), wantPrevious: true},
+		{name: "grace period over", rotatedAt: time.Now().Add(-169 * time.Hour), wantPrevious: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keyring := newTestKeyring(t, map[string]string{
+				"APP_KEY":            "new-key",
+				"APP_KEY_ID":         "2026-10",
+				"APP_PREVIOUS_KEYS":  "2026-04=old-key, 2025-10=older-key",
+				"APP_KEY_ROTATED_AT": test.rotatedAt.Format(time.RFC3339),
+			})
+
+			if keyring.CurrentKid != "2026-10" {
+				t.Fatalf("CurrentKid = %q, want 2026-10", keyring.CurrentKid)
+			}
+
+			// the current key never expires
+			key, ok := keyring.Key("2026-10")
+			if !ok || string(key) != "new-key" {
+				t.Fatalf("Key(2026-10) = %q, %v", key, ok)
+			}
+
+			for kid, want := range map[string]string{"2026-04": "old-key", "2025-10": "older-key"} {
+				key, ok := keyring.Key(kid)
+				if ok != test.wantPrevious {
+					t.Fatalf("Key(%s) found = %v, want %v", kid, ok, test.wantPrevious)
+				}
+				if ok && string(key) != want {
+					t.Fatalf("Key(%s) = %q, want %q", kid, key, want)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyringIgnoresCurrentKidInPreviousKeys(t *testing.T) {
+	keyring := newTestKeyring(t, map[string]string{
+		"APP_KEY":            "new-key",
+		"APP_KEY_ID":         "2026-10",
+		"APP_PREVIOUS_KEYS":  "2026-10=stale-key,broken,=no-kid",
+		"APP_KEY_ROTATED_AT": time.Now().Add(-time.Hour).Format(time.RFC3339),
+	})
+
+	key, ok := keyring.Key("2026-10")
+	if !ok || string(key) != "new-key" {
+		t.Fatalf("Key(2026-10) = %q, %v", key, ok)
+	}
+
+	if len(keyring.PreviousKids) != 0 {
+		t.Fatalf("PreviousKids = %v, want none", keyring.PreviousKids)
+	}
+}
@@ -5,8 +5,41 @@ import (
 )
 
 type SendOtpEmailData struct {
-	OtpCode string
-	Email   string
+	OtpCode   string
+	Email     string
+	ExpiresIn string
+}
+
+type VerifyEmailData struct {
+	Email           string
+	VerificationUrl string
+	ExpiresIn       string
+}
+
+type UnlockAccountData struct {
+	Email           string
+	UnlockUrl       string
+	LockoutDuration string
+}
+
+type MagicLinkData struct {
+	Email     string
+	LoginUrl  string
+	ExpiresIn string
+}
+
+type NewDeviceLoginData struct {
+	Email       string
+	DeviceLabel string
+	IpAddress   string
+	UserAgent   string
+	SignedInAt  string
+	ReportUrl   string
+	ExpiresIn   string
+}
+
+type PasswordChangedEmailData struct {
+	Name string
 }
 
 type Mailer struct {
@@ -1,30 +1,98 @@
 package config
 
 import (
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
+	"log"
+	"net/http"
+	"strings"
 )
 
-const (
-	GoogleUserInfoEndpoint = "https://www.googleapis.com/oauth2/v2/userinfo"
-)
+// OidcProvider is an OpenID Connect provider read from the config. Every
+// provider listed in OAUTH_PROVIDERS is configured with OAUTH_<NAME>_* keys.
+// The endpoints are discovered from the issuer unless set explicitly, which
+// is also how a local fake provider can stand in for a real one.
+type OidcProvider struct {
+	Name         string
+	Issuer       string
+	Issuers      []string
+	ClientId     string
+	ClientSecret string
+	Scopes       []string
+	RedirectUrl  string
+	AuthUrl      string
+	TokenUrl     string
+	JwksUrl      string
+	TrustEmail   bool
+}
 
 type OauthClient struct {
-	Google *oauth2.Config
+	Providers  map[string]*OidcProvider
+	HttpClient *http.Client
 }
 
 func NewOauthClient(cnf *Config) *OauthClient {
+	providers := make(map[string]*OidcProvider)
+	for _, name := range strings.Split(cnf.Env.GetString("OAUTH_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		providers[name] = initOidcProvider(cnf, name)
+	}
+
 	return &OauthClient{
-		Google: initGoogleOauth(cnf),
+		Providers:  providers,
+		HttpClient: &http.Client{Timeout: cnf.Env.GetDuration("OAUTH_HTTP_TIMEOUT")},
 	}
 }
 
-func initGoogleOauth(cnf *Config) *oauth2.Config {
-	return &oauth2.Config{
-		ClientID:     cnf.Env.GetString("GOOGLE_OAUTH_CLIENT_ID"),
-		ClientSecret: cnf.Env.GetString("GOOGLE_OAUTH_CLIENT_SECRET"),
-		RedirectURL:  "http://localhost:3000/api/auth/google/callback",
-		Scopes:       []string{"profile", "email"},
-		Endpoint:     google.Endpoint,
+func (o *OauthClient) Provider(name string) (*OidcProvider, bool) {
+	provider, ok := o.Providers[strings.ToLower(name)]
+	return provider, ok
+}
+
+func initOidcProvider(cnf *Config, name string) *OidcProvider {
+	key := func(suffix string) string {
+		return "OAUTH_" + strings.ToUpper(name) + "_" + suffix
+	}
+
+	// google was configured with GOOGLE_OAUTH_* keys before other providers
+	// were supported
+	credential := func(suffix string) string {
+		value := cnf.Env.GetString(key(suffix))
+		if value == "" && name == "google" && cnf.Env.GetString("GOOGLE_OAUTH_"+suffix) != "" {
+			log.Printf("GOOGLE_OAUTH_%s is deprecated, set %s instead", suffix, key(suffix))
+			value = cnf.Env.GetString("GOOGLE_OAUTH_" + suffix)
+		}
+		return value
+	}
+
+	issuer := cnf.Env.GetString(key("ISSUER"))
+	issuers := []string{issuer}
+	if allowed := cnf.Env.GetString(key("ALLOWED_ISSUERS")); allowed != "" {
+		issuers = strings.Split(allowed, ",")
+	}
+
+	redirectUrl := cnf.Env.GetString(key("REDIRECT_URL"))
+	if redirectUrl == "" {
+		redirectUrl = strings.TrimRight(cnf.Env.GetString("APP_URL"), "/") + "/api/auth/" + name + "/callback"
+	}
+
+	scopes := strings.Split(cnf.Env.GetString(key("SCOPES")), ",")
+	if cnf.Env.GetString(key("SCOPES")) == "" {
+		scopes = []string{"openid", "profile", "email"}
+	}
+
+	return &OidcProvider{
+		Name:         name,
+		Issuer:       issuer,
+		Issuers:      issuers,
+		ClientId:     credential("CLIENT_ID"),
+		ClientSecret: credential("CLIENT_SECRET"),
+		Scopes:       scopes,
+		RedirectUrl:  redirectUrl,
+		AuthUrl:      cnf.Env.GetString(key("AUTH_URL")),
+		TokenUrl:     cnf.Env.GetString(key("TOKEN_URL")),
+		JwksUrl:      cnf.Env.GetString(key("JWKS_URL")),
+		TrustEmail:   cnf.Env.GetBool(key("TRUST_EMAIL")),
 	}
 }
@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type AccountDeletionController interface {
+	RequestDeletion(ctx *fiber.Ctx) error
+	GetReceipt(ctx *fiber.Ctx) error
+}
+
+type AccountDeletionControllerImpl struct {
+	AccountDeletionService service.AccountDeletionService
+}
+
+func NewAccountDeletionController(accountDeletionService service.AccountDeletionService) *AccountDeletionControllerImpl {
+	return &AccountDeletionControllerImpl{AccountDeletionService: accountDeletionService}
+}
+
+func (a AccountDeletionControllerImpl) RequestDeletion(ctx *fiber.Ctx) error {
+	req := &model.DeleteAccountRequest{}
+	err := ctx.BodyParser(req)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid request body")
+	}
+
+	user := ctx.UserContext().Value("user").(*model.User)
+	session := ctx.UserContext().Value("session").(*model.Session)
+
+	resp, err := a.AccountDeletionService.RequestDeletion(ctx.Context(), user, session, *req)
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Account deletion scheduled",
+		Data:    resp,
+		Errors:  nil,
+	}
+
+	return ctx.Status(fiber.StatusAccepted).JSON(&globalResponse)
+}
+
+func (a AccountDeletionControllerImpl) GetReceipt(ctx *fiber.Ctx) error {
+	resp, err := a.AccountDeletionService.GetReceipt(ctx.Context(), ctx.Params("receiptId"))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Success get deletion receipt",
+		Data:    resp,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type ApiKeyController interface {
+	Create(ctx *fiber.Ctx) error
+	GetAll(ctx *fiber.Ctx) error
+	Revoke(ctx *fiber.Ctx) error
+}
+
+type ApiKeyControllerImpl struct {
+	ApiKeyService service.ApiKeyService
+}
+
+func NewApiKeyController(apiKeyService service.ApiKeyService) *ApiKeyControllerImpl {
+	return &ApiKeyControllerImpl{ApiKeyService: apiKeyService}
+}
+
+func (a ApiKeyControllerImpl) Create(ctx *fiber.Ctx) error {
+	req := &model.CreateApiKeyRequest{}
+	err := ctx.BodyParser(req)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid request body")
+	}
+
+	user := ctx.UserContext().Value("user").(*model.User)
+
+	resp, err := a.ApiKeyService.Create(ctx.Context(), user, *req, clientInfo(ctx))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Success create API key, store it now as it will not be shown again",
+		Data:    resp,
+		Errors:  nil,
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(&globalResponse)
+}
+
+func (a ApiKeyControllerImpl) GetAll(ctx *fiber.Ctx) error {
+	user := ctx.UserContext().Value("user").(*model.User)
+
+	resp, err := a.ApiKeyService.GetAll(ctx.Context(), user)
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Success get API keys",
+		Data:    resp,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
+
+func (a ApiKeyControllerImpl) Revoke(ctx *fiber.Ctx) error {
+	apiKeyId, err := ctx.ParamsInt("apiKeyId")
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid API key id")
+	}
+
+	user := ctx.UserContext().Value("user").(*model.User)
+
+	err = a.ApiKeyService.Revoke(ctx.Context(), user, apiKeyId, clientInfo(ctx))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Success revoke API key",
+		Data:    nil,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type AuditController interface {
+	GetMine(ctx *fiber.Ctx) error
+	Search(ctx *fiber.Ctx) error
+}
+
+type AuditControllerImpl struct {
+	AuditService service.AuditService
+}
+
+func NewAuditController(auditService service.AuditService) *AuditControllerImpl {
+	return &AuditControllerImpl{AuditService: auditService}
+}
+
+func (a AuditControllerImpl) GetMine(ctx *fiber.Ctx) error {
+	query := &model.AuditEventQuery{}
+	err := ctx.QueryParser(query)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid query parameters")
+	}
+
+	user := ctx.UserContext().Value("user").(*model.User)
+
+	resp, err := a.AuditService.GetMine(ctx.Context(), user, *query)
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Success get security events",
+		Data:    resp,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
+
+func (a AuditControllerImpl) Search(ctx *fiber.Ctx) error {
+	query := &model.AuditEventQuery{}
+	err := ctx.QueryParser(query)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid query parameters")
+	}
+
+	resp, err := a.AuditService.Search(ctx.Context(), *query)
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Success get audit events",
+		Data:    resp,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
@@ -5,6 +5,7 @@ import (
 	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
 	"akmmp241/dinamcom-2024/dinacom-go-rest/service"
 	"github.com/gofiber/fiber/v2"
+	"strings"
 )
 
 type AuthController interface {
@@ -14,7 +15,24 @@ type AuthController interface {
 	ForgetPassword(c *fiber.Ctx) error
 	VerifyForgetPasswordOtp(c *fiber.Ctx) error
 	ResetPassword(c *fiber.Ctx) error
-	GoogleCallback(c *fiber.Ctx) error
+	OauthLogin(c *fiber.Ctx) error
+	OauthCallback(c *fiber.Ctx) error
+	LinkIdentity(c *fiber.Ctx) error
+	GetIdentities(c *fiber.Ctx) error
+	UnlinkIdentity(c *fiber.Ctx) error
+	Refresh(c *fiber.Ctx) error
+	Logout(c *fiber.Ctx) error
+	LogoutAll(c *fiber.Ctx) error
+	GetSessions(c *fiber.Ctx) error
+	RevokeSession(c *fiber.Ctx) error
+	VerifyEmail(c *fiber.Ctx) error
+	ResendVerificationEmail(c *fiber.Ctx) error
+	LoginTwoFactor(c *fiber.Ctx) error
+	SendMagicLink(c *fiber.Ctx) error
+	MagicLinkLogin(c *fiber.Ctx) error
+	UnlockAccount(c *fiber.Ctx) error
+	ChangePassword(c *fiber.Ctx) error
+	ReportDevice(c *fiber.Ctx) error
 }
 
 type AuthControllerImpl struct {
@@ -28,7 +46,7 @@ func (con *AuthControllerImpl) Register(c *fiber.Ctx) error {
 		return exceptions.NewBadRequestError("Invalid request body")
 	}
 
-	registerResponse, err := con.AuthService.Register(c.Context(), *registerRequest)
+	registerResponse, err := con.AuthService.Register(c.Context(), *registerRequest, clientInfo(c))
 	if err != nil {
 		return err
 	}
@@ -49,7 +67,70 @@ func (con *AuthControllerImpl) Login(c *fiber.Ctx) error {
 		return exceptions.NewBadRequestError("Invalid request body")
 	}
 
-	loginResponse, err := con.AuthService.Login(c.Context(), *loginRequest)
+	loginResponse, err := con.AuthService.Login(c.Context(), *loginRequest, clientInfo(c))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Login success",
+		Data:    &loginResponse,
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+func (con *AuthControllerImpl) LoginTwoFactor(c *fiber.Ctx) error {
+	req := &model.LoginTwoFactorRequest{}
+	err := c.BodyParser(req)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid request body")
+	}
+
+	loginResponse, err := con.AuthService.LoginTwoFactor(c.Context(), *req, clientInfo(c))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Login success",
+		Data:    &loginResponse,
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+func (con *AuthControllerImpl) SendMagicLink(c *fiber.Ctx) error {
+	req := &model.MagicLinkRequest{}
+	err := c.BodyParser(req)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid request body")
+	}
+
+	err = con.AuthService.SendMagicLink(c.Context(), *req)
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "If the email is registered, a sign in link has been sent",
+		Data:    nil,
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+func (con *AuthControllerImpl) MagicLinkLogin(c *fiber.Ctx) error {
+	req := &model.MagicLinkLoginRequest{}
+	err := c.BodyParser(req)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid request body")
+	}
+
+	loginResponse, err := con.AuthService.MagicLinkLogin(c.Context(), *req, clientInfo(c))
 	if err != nil {
 		return err
 	}
@@ -90,7 +171,7 @@ func (con *AuthControllerImpl) ForgetPassword(c *fiber.Ctx) error {
 		return exceptions.NewBadRequestError("Invalid request body")
 	}
 
-	err = con.AuthService.ForgetPassword(c.Context(), *forgetPasswordRequest)
+	err = con.AuthService.ForgetPassword(c.Context(), *forgetPasswordRequest, clientInfo(c))
 	if err != nil {
 		return err
 	}
@@ -111,7 +192,7 @@ func (con *AuthControllerImpl) VerifyForgetPasswordOtp(c *fiber.Ctx) error {
 		return exceptions.NewBadRequestError("Invalid request body")
 	}
 
-	verifyForgetPasswordOtpResponse, err := con.AuthService.VerifyForgetPasswordOtp(c.Context(), *req)
+	verifyForgetPasswordOtpResponse, err := con.AuthService.VerifyForgetPasswordOtp(c.Context(), *req, clientInfo(c))
 	if err != nil {
 		return err
 	}
@@ -132,7 +213,7 @@ func (con *AuthControllerImpl) ResetPassword(c *fiber.Ctx) error {
 		return exceptions.NewBadRequestError("Invalid request body")
 	}
 
-	message, err := con.AuthService.ResetPassword(c.Context(), *resetPasswordRequest)
+	message, err := con.AuthService.ResetPassword(c.Context(), *resetPasswordRequest, clientInfo(c))
 	if err != nil {
 		return err
 	}
@@ -145,18 +226,92 @@ func (con *AuthControllerImpl) ResetPassword(c *fiber.Ctx) error {
 	return c.JSON(&globalResponse)
 }
 
-func (con *AuthControllerImpl) GoogleCallback(c *fiber.Ctx) error {
-	req := &model.GoogleCallbackRequest{}
-	err := c.BodyParser(req)
+const oauthStateCookie = "oauth_state"
+
+func (con *AuthControllerImpl) OauthLogin(c *fiber.Ctx) error {
+	provider := c.Params("provider")
+
+	authUrl, state, err := con.AuthService.OauthLogin(c.Context(), provider)
 	if err != nil {
-		return exceptions.NewBadRequestError("Invalid request body")
+		return err
 	}
 
-	loginResponse, err := con.AuthService.GoogleCallback(c.Context(), *req)
+	setOauthStateCookie(c, provider, state)
+
+	return c.Redirect(authUrl, fiber.StatusFound)
+}
+
+func (con *AuthControllerImpl) LinkIdentity(c *fiber.Ctx) error {
+	user := c.UserContext().Value("user").(*model.User)
+	provider := c.Params("provider")
+
+	authUrl, state, err := con.AuthService.LinkIdentity(c.Context(), user, provider)
 	if err != nil {
 		return err
 	}
 
+	setOauthStateCookie(c, provider, state)
+
+	globalResponse := model.GlobalResponse{
+		Message: "Continue linking at the authorization url",
+		Data:    &model.LinkIdentityResponse{AuthorizationUrl: authUrl},
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+// setOauthStateCookie ties the callback to the browser that started the flow,
+// so an attacker cannot finish a flow they started in a victim's browser.
+func setOauthStateCookie(c *fiber.Ctx, provider string, state string) {
+	c.Cookie(&fiber.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     oauthCookiePath(provider),
+		MaxAge:   600,
+		Secure:   c.Protocol() == "https",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+func oauthCookiePath(provider string) string {
+	return "/api/auth/" + strings.ToLower(provider)
+}
+
+func (con *AuthControllerImpl) OauthCallback(c *fiber.Ctx) error {
+	if c.Query("error") != "" {
+		return exceptions.NewBadRequestError("Sign in was cancelled")
+	}
+
+	provider := c.Params("provider")
+	req := &model.OauthCallbackRequest{}
+	err := c.QueryParser(req)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid request query")
+	}
+	req.BoundState = c.Cookies(oauthStateCookie)
+	c.Cookie(&fiber.Cookie{
+		Name:   oauthStateCookie,
+		Path:   oauthCookiePath(provider),
+		MaxAge: -1,
+	})
+
+	loginResponse, identityResponse, err := con.AuthService.OauthCallback(c.Context(), provider, *req, clientInfo(c))
+	if err != nil {
+		return err
+	}
+
+	if identityResponse != nil {
+		globalResponse := model.GlobalResponse{
+			Message: "Identity linked",
+			Data:    identityResponse,
+			Errors:  nil,
+		}
+
+		return c.JSON(&globalResponse)
+	}
+
 	globalResponse := model.GlobalResponse{
 		Message: "Login success",
 		Data:    &loginResponse,
@@ -166,6 +321,245 @@ func (con *AuthControllerImpl) GoogleCallback(c *fiber.Ctx) error {
 	return c.JSON(&globalResponse)
 }
 
+func (con *AuthControllerImpl) GetIdentities(c *fiber.Ctx) error {
+	user := c.UserContext().Value("user").(*model.User)
+
+	identities, err := con.AuthService.GetIdentities(c.Context(), user)
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Success get identities",
+		Data:    identities,
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+func (con *AuthControllerImpl) UnlinkIdentity(c *fiber.Ctx) error {
+	user := c.UserContext().Value("user").(*model.User)
+
+	err := con.AuthService.UnlinkIdentity(c.Context(), user, c.Params("provider"))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Identity unlinked",
+		Data:    nil,
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+func (con *AuthControllerImpl) Refresh(c *fiber.Ctx) error {
+	req := &model.RefreshTokenRequest{}
+	err := c.BodyParser(req)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid request body")
+	}
+
+	refreshTokenResponse, err := con.AuthService.Refresh(c.Context(), *req, clientInfo(c))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Refresh token success",
+		Data:    refreshTokenResponse,
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+func (con *AuthControllerImpl) Logout(c *fiber.Ctx) error {
+	user := c.UserContext().Value("user").(*model.User)
+	session := c.UserContext().Value("session").(*model.Session)
+
+	err := con.AuthService.Logout(c.Context(), user, session, clientInfo(c))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Logout success",
+		Data:    nil,
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+func (con *AuthControllerImpl) LogoutAll(c *fiber.Ctx) error {
+	user := c.UserContext().Value("user").(*model.User)
+
+	err := con.AuthService.LogoutAll(c.Context(), user, clientInfo(c))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Logout from all sessions success",
+		Data:    nil,
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+func (con *AuthControllerImpl) GetSessions(c *fiber.Ctx) error {
+	user := c.UserContext().Value("user").(*model.User)
+	session := c.UserContext().Value("session").(*model.Session)
+
+	sessionResponses, err := con.AuthService.GetSessions(c.Context(), user, session)
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Get sessions success",
+		Data:    sessionResponses,
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+func (con *AuthControllerImpl) RevokeSession(c *fiber.Ctx) error {
+	sessionId, err := c.ParamsInt("sessionId")
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid session id")
+	}
+
+	user := c.UserContext().Value("user").(*model.User)
+
+	err = con.AuthService.RevokeSession(c.Context(), user, sessionId, clientInfo(c))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Revoke session success",
+		Data:    nil,
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+func (con *AuthControllerImpl) VerifyEmail(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return exceptions.NewBadRequestError("Missing verification token")
+	}
+
+	err := con.AuthService.VerifyEmail(c.Context(), token)
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Email verified",
+		Data:    nil,
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+func (con *AuthControllerImpl) ResendVerificationEmail(c *fiber.Ctx) error {
+	user := c.UserContext().Value("user").(*model.User)
+
+	err := con.AuthService.ResendVerificationEmail(c.Context(), user)
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Send verification email success",
+		Data:    nil,
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+func (con *AuthControllerImpl) UnlockAccount(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return exceptions.NewBadRequestError("Missing unlock token")
+	}
+
+	err := con.AuthService.UnlockAccount(c.Context(), token)
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Account unlocked",
+		Data:    nil,
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+func (con *AuthControllerImpl) ChangePassword(c *fiber.Ctx) error {
+	req := &model.ChangePasswordRequest{}
+	err := c.BodyParser(req)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid request body")
+	}
+
+	user := c.UserContext().Value("user").(*model.User)
+	session := c.UserContext().Value("session").(*model.Session)
+
+	err = con.AuthService.ChangePassword(c.Context(), user, session, *req, clientInfo(c))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Change password success",
+		Data:    nil,
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+func (con *AuthControllerImpl) ReportDevice(c *fiber.Ctx) error {
+	req := &model.ReportDeviceRequest{}
+	err := c.BodyParser(req)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid request body")
+	}
+
+	err = con.AuthService.ReportDevice(c.Context(), *req, clientInfo(c))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "The device has been signed out, please reset your password",
+		Data:    nil,
+		Errors:  nil,
+	}
+
+	return c.JSON(&globalResponse)
+}
+
+func clientInfo(c *fiber.Ctx) model.ClientInfo {
+	return model.ClientInfo{
+		IpAddress: c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+}
+
 func NewAuthController(authService service.AuthService) AuthController {
 	return &AuthControllerImpl{AuthService: authService}
 }
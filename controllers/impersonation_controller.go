@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type ImpersonationController interface {
+	Impersonate(ctx *fiber.Ctx) error
+}
+
+type ImpersonationControllerImpl struct {
+	ImpersonationService service.ImpersonationService
+}
+
+func NewImpersonationController(impersonationService service.ImpersonationService) *ImpersonationControllerImpl {
+	return &ImpersonationControllerImpl{ImpersonationService: impersonationService}
+}
+
+func (i ImpersonationControllerImpl) Impersonate(ctx *fiber.Ctx) error {
+	userId, err := ctx.ParamsInt("userId")
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid user id")
+	}
+
+	req := &model.ImpersonateRequest{}
+	err = ctx.BodyParser(req)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid request body")
+	}
+
+	admin := ctx.UserContext().Value("user").(*model.User)
+	session := ctx.UserContext().Value("session").(*model.Session)
+
+	resp, err := i.ImpersonationService.Impersonate(ctx.Context(), admin, session, userId, *req, clientInfo(ctx))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Success impersonate user",
+		Data:    resp,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type ProfileController interface {
+	Get(ctx *fiber.Ctx) error
+	Update(ctx *fiber.Ctx) error
+	Delete(ctx *fiber.Ctx) error
+}
+
+type ProfileControllerImpl struct {
+	ProfileService service.ProfileService
+}
+
+func NewProfileController(profileService service.ProfileService) *ProfileControllerImpl {
+	return &ProfileControllerImpl{ProfileService: profileService}
+}
+
+func (p ProfileControllerImpl) Get(ctx *fiber.Ctx) error {
+	user := ctx.UserContext().Value("user").(*model.User)
+
+	resp, err := p.ProfileService.Get(ctx.Context(), user)
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Success get profile",
+		Data:    resp,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
+
+func (p ProfileControllerImpl) Update(ctx *fiber.Ctx) error {
+	req := &model.UpdateProfileRequest{}
+	err := ctx.BodyParser(req)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid request body")
+	}
+
+	user := ctx.UserContext().Value("user").(*model.User)
+
+	resp, err := p.ProfileService.Update(ctx.Context(), user, *req)
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Success update profile",
+		Data:    resp,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
+
+func (p ProfileControllerImpl) Delete(ctx *fiber.Ctx) error {
+	user := ctx.UserContext().Value("user").(*model.User)
+
+	err := p.ProfileService.Delete(ctx.Context(), user)
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Success delete profile",
+		Data:    nil,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
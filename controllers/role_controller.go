@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type RoleController interface {
+	GetRoles(ctx *fiber.Ctx) error
+	GetUserRoles(ctx *fiber.Ctx) error
+	AssignRole(ctx *fiber.Ctx) error
+	RevokeRole(ctx *fiber.Ctx) error
+}
+
+type RoleControllerImpl struct {
+	RoleService service.RoleService
+}
+
+func NewRoleController(roleService service.RoleService) *RoleControllerImpl {
+	return &RoleControllerImpl{RoleService: roleService}
+}
+
+func (r RoleControllerImpl) GetRoles(ctx *fiber.Ctx) error {
+	resp, err := r.RoleService.GetRoles(ctx.Context())
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Success get roles",
+		Data:    resp,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
+
+func (r RoleControllerImpl) GetUserRoles(ctx *fiber.Ctx) error {
+	userId, err := ctx.ParamsInt("userId")
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid user id")
+	}
+
+	resp, err := r.RoleService.GetUserRoles(ctx.Context(), userId)
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Success get user roles",
+		Data:    resp,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
+
+func (r RoleControllerImpl) AssignRole(ctx *fiber.Ctx) error {
+	userId, err := ctx.ParamsInt("userId")
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid user id")
+	}
+
+	req := &model.AssignRoleRequest{}
+	err = ctx.BodyParser(req)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid request body")
+	}
+
+	admin := ctx.UserContext().Value("user").(*model.User)
+
+	resp, err := r.RoleService.AssignRole(ctx.Context(), admin, userId, *req, clientInfo(ctx))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Success assign role",
+		Data:    resp,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
+
+func (r RoleControllerImpl) RevokeRole(ctx *fiber.Ctx) error {
+	userId, err := ctx.ParamsInt("userId")
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid user id")
+	}
+
+	admin := ctx.UserContext().Value("user").(*model.User)
+
+	resp, err := r.RoleService.RevokeRole(ctx.Context(), admin, userId, ctx.Params("role"), clientInfo(ctx))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Success revoke role",
+		Data:    resp,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
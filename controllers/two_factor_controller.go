@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type TwoFactorController interface {
+	Enroll(ctx *fiber.Ctx) error
+	Confirm(ctx *fiber.Ctx) error
+	Disable(ctx *fiber.Ctx) error
+}
+
+type TwoFactorControllerImpl struct {
+	TwoFactorService service.TwoFactorService
+}
+
+func NewTwoFactorController(twoFactorService service.TwoFactorService) *TwoFactorControllerImpl {
+	return &TwoFactorControllerImpl{TwoFactorService: twoFactorService}
+}
+
+func (t TwoFactorControllerImpl) Enroll(ctx *fiber.Ctx) error {
+	req := &model.TwoFactorEnrollRequest{}
+	err := ctx.BodyParser(req)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid request body")
+	}
+
+	user := ctx.UserContext().Value("user").(*model.User)
+	session := ctx.UserContext().Value("session").(*model.Session)
+
+	resp, err := t.TwoFactorService.Enroll(ctx.Context(), user, session, *req)
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Two-factor enrollment started",
+		Data:    resp,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
+
+func (t TwoFactorControllerImpl) Confirm(ctx *fiber.Ctx) error {
+	req := &model.TwoFactorCodeRequest{}
+	err := ctx.BodyParser(req)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid request body")
+	}
+
+	user := ctx.UserContext().Value("user").(*model.User)
+
+	resp, err := t.TwoFactorService.Confirm(ctx.Context(), user, *req, clientInfo(ctx))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Two-factor authentication enabled",
+		Data:    resp,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
+
+func (t TwoFactorControllerImpl) Disable(ctx *fiber.Ctx) error {
+	req := &model.TwoFactorDisableRequest{}
+	err := ctx.BodyParser(req)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid request body")
+	}
+
+	user := ctx.UserContext().Value("user").(*model.User)
+	session := ctx.UserContext().Value("session").(*model.Session)
+
+	err = t.TwoFactorService.Disable(ctx.Context(), user, session, *req, clientInfo(ctx))
+	if err != nil {
+		return err
+	}
+
+	globalResponse := model.GlobalResponse{
+		Message: "Two-factor authentication disabled",
+		Data:    nil,
+		Errors:  nil,
+	}
+
+	return ctx.JSON(&globalResponse)
+}
@@ -0,0 +1 @@
+ALTER TABLE sessions DROP COLUMN revoked_at;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE sessions
+    ADD COLUMN revoked_at timestamp null default null;
\ No newline at end of file
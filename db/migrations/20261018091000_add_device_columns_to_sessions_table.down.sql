@@ -0,0 +1,6 @@
+ALTER TABLE sessions
+    DROP COLUMN created_at,
+    DROP COLUMN last_seen_at,
+    DROP COLUMN ip_address,
+    DROP COLUMN user_agent,
+    DROP COLUMN device_label;
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE sessions
+    ADD COLUMN created_at   timestamp    not null default current_timestamp,
+    ADD COLUMN last_seen_at timestamp    not null default current_timestamp,
+    ADD COLUMN ip_address   varchar(45)  not null default '',
+    ADD COLUMN user_agent   varchar(512) not null default '',
+    ADD COLUMN device_label varchar(255) not null default '';
\ No newline at end of file
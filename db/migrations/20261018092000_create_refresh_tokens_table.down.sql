@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS refresh_tokens;
+
+ALTER TABLE sessions DROP COLUMN access_expires_at;
\ No newline at end of file
@@ -0,0 +1,16 @@
+ALTER TABLE sessions
+    ADD COLUMN access_expires_at timestamp not null default current_timestamp;
+
+-- sessions from before refresh tokens have none to refresh with, so their
+-- access tokens stay valid for the whole session as they used to
+UPDATE sessions SET access_expires_at = expires_at;
+
+CREATE TABLE refresh_tokens (
+    id         int unsigned not null auto_increment primary key,
+    session_id int unsigned not null,
+    token      varchar(255) not null unique,
+    expires_at timestamp    not null,
+    used_at    timestamp    null default null,
+    created_at timestamp    not null,
+    CONSTRAINT fk_session_id_refresh_tokens FOREIGN KEY (session_id) REFERENCES sessions(id)
+) engine innodb;
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE users
+    ADD COLUMN email_verified_at timestamp null default null;
+
+-- accounts created before verification existed keep working
+UPDATE users SET email_verified_at = current_timestamp;
\ No newline at end of file
@@ -0,0 +1,12 @@
+ALTER TABLE users
+    ADD COLUMN totp_secret     varchar(64) not null default '',
+    ADD COLUMN totp_enabled_at timestamp   null default null;
+
+CREATE TABLE recovery_codes (
+    id         int unsigned not null auto_increment primary key,
+    user_id    int unsigned not null,
+    code       varchar(255) not null,
+    used_at    timestamp    null default null,
+    created_at timestamp    not null,
+    CONSTRAINT fk_user_id_recovery_codes FOREIGN KEY (user_id) REFERENCES users(id)
+) engine innodb;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE complaints DROP COLUMN gemini_file_name;
\ No newline at end of file
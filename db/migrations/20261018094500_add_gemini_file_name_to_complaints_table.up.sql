@@ -0,0 +1,2 @@
+ALTER TABLE complaints
+    ADD COLUMN gemini_file_name varchar(255) not null default '';
\ No newline at end of file
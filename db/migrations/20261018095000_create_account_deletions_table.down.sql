@@ -0,0 +1 @@
+DROP TABLE IF EXISTS account_deletions;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE account_deletions (
+    id                 varchar(255) not null primary key,
+    user_id            int unsigned not null,
+    status             ENUM('pending', 'running', 'completed', 'failed') not null default 'pending',
+    attempts           int unsigned not null default 0,
+    last_error         varchar(1024) not null default '',
+    complaints_deleted int unsigned not null default 0,
+    images_deleted     int unsigned not null default 0,
+    ai_files_deleted   int unsigned not null default 0,
+    requested_at       timestamp    not null,
+    started_at         timestamp    null default null,
+    completed_at       timestamp    null default null
+) engine innodb;
\ No newline at end of file
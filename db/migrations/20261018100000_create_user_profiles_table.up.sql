@@ -0,0 +1,13 @@
+CREATE TABLE user_profiles (
+    user_id             int unsigned not null primary key,
+    display_name        varchar(255) not null default '',
+    avatar_url          varchar(1024) not null default '',
+    date_of_birth       date         null default null,
+    sex                 ENUM('male', 'female', 'other') null default null,
+    allergies           json         not null,
+    chronic_conditions  json         not null,
+    current_medications json         not null,
+    created_at          timestamp    not null,
+    updated_at          timestamp    not null,
+    CONSTRAINT fk_user_id_user_profiles FOREIGN KEY (user_id) REFERENCES users(id)
+) engine innodb;
\ No newline at end of file
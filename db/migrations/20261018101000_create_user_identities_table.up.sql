@@ -0,0 +1,11 @@
+CREATE TABLE user_identities (
+    id         int unsigned not null auto_increment primary key,
+    user_id    int unsigned not null,
+    provider   varchar(32)  not null,
+    subject    varchar(255) not null,
+    email      varchar(255) not null default '',
+    created_at timestamp    not null,
+    UNIQUE KEY uq_provider_subject_user_identities (provider, subject),
+    UNIQUE KEY uq_user_id_provider_user_identities (user_id, provider),
+    CONSTRAINT fk_user_id_user_identities FOREIGN KEY (user_id) REFERENCES users(id)
+) engine innodb;
\ No newline at end of file
@@ -0,0 +1,6 @@
+-- the enum only knows the original providers, accounts of any other keep
+-- working through their identities
+UPDATE users SET provider = NULL WHERE provider NOT IN ('email', 'google');
+
+ALTER TABLE users
+    MODIFY COLUMN provider ENUM('email', 'google') DEFAULT NULL;
\ No newline at end of file
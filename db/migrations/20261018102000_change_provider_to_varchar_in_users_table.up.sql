@@ -0,0 +1,2 @@
+ALTER TABLE users
+    MODIFY COLUMN provider varchar(32) DEFAULT NULL;
\ No newline at end of file
@@ -0,0 +1,43 @@
+CREATE TABLE roles (
+    id          int unsigned not null auto_increment primary key,
+    name        varchar(64)  not null unique,
+    description varchar(255) not null default ''
+) engine innodb;
+
+CREATE TABLE permissions (
+    id          int unsigned not null auto_increment primary key,
+    name        varchar(64)  not null unique,
+    description varchar(255) not null default ''
+) engine innodb;
+
+CREATE TABLE role_permissions (
+    role_id       int unsigned not null,
+    permission_id int unsigned not null,
+    PRIMARY KEY (role_id, permission_id),
+    CONSTRAINT fk_role_id_role_permissions FOREIGN KEY (role_id) REFERENCES roles(id),
+    CONSTRAINT fk_permission_id_role_permissions FOREIGN KEY (permission_id) REFERENCES permissions(id)
+) engine innodb;
+
+CREATE TABLE user_roles (
+    user_id    int unsigned not null,
+    role_id    int unsigned not null,
+    created_at timestamp    not null,
+    PRIMARY KEY (user_id, role_id),
+    CONSTRAINT fk_user_id_user_roles FOREIGN KEY (user_id) REFERENCES users(id),
+    CONSTRAINT fk_role_id_user_roles FOREIGN KEY (role_id) REFERENCES roles(id)
+) engine innodb;
+
+INSERT INTO roles (name, description) VALUES
+    ('user', 'Every signed in user, granted implicitly'),
+    ('clinician', 'Reviews complaints submitted by patients'),
+    ('admin', 'Manages users and their roles');
+
+INSERT INTO permissions (name, description) VALUES
+    ('complaints:read_any', 'Read complaints of any user'),
+    ('users:read', 'Read any user account'),
+    ('roles:manage', 'Grant and revoke roles');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id FROM roles JOIN permissions
+WHERE (roles.name = 'clinician' AND permissions.name IN ('complaints:read_any'))
+   OR (roles.name = 'admin' AND permissions.name IN ('complaints:read_any', 'users:read', 'roles:manage'));
\ No newline at end of file
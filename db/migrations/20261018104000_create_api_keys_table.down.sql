@@ -0,0 +1,3 @@
+DELETE role_permissions FROM role_permissions JOIN permissions ON permissions.id = role_permissions.permission_id WHERE permissions.name = 'api_keys:manage';
+DELETE FROM permissions WHERE name = 'api_keys:manage';
+DROP TABLE IF EXISTS api_keys;
\ No newline at end of file
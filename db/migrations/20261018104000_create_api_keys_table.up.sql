@@ -0,0 +1,21 @@
+CREATE TABLE api_keys (
+    id           int unsigned not null auto_increment primary key,
+    user_id      int unsigned not null,
+    name         varchar(100) not null,
+    prefix       varchar(16)  not null,
+    key_hash     char(64)     not null,
+    scopes       json         not null,
+    expires_at   timestamp    not null,
+    last_used_at timestamp    null,
+    revoked_at   timestamp    null,
+    created_at   timestamp    not null,
+    UNIQUE KEY uq_key_hash_api_keys (key_hash),
+    CONSTRAINT fk_user_id_api_keys FOREIGN KEY (user_id) REFERENCES users(id)
+) engine innodb;
+
+INSERT INTO permissions (name, description) VALUES
+    ('api_keys:manage', 'Issue API keys for server to server integrations');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id FROM roles JOIN permissions
+WHERE roles.name IN ('clinician', 'admin') AND permissions.name = 'api_keys:manage';
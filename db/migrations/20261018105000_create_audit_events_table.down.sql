@@ -0,0 +1,3 @@
+DELETE role_permissions FROM role_permissions JOIN permissions ON permissions.id = role_permissions.permission_id WHERE permissions.name = 'audit:read';
+DELETE FROM permissions WHERE name = 'audit:read';
+DROP TABLE IF EXISTS audit_events;
\ No newline at end of file
@@ -0,0 +1,21 @@
+CREATE TABLE audit_events (
+    id         bigint unsigned not null auto_increment primary key,
+    user_id    int unsigned    null,
+    email      varchar(255)    not null default '',
+    event      varchar(64)     not null,
+    outcome    varchar(16)     not null,
+    ip_address varchar(45)     not null default '',
+    user_agent varchar(512)    not null default '',
+    detail     varchar(255)    not null default '',
+    created_at timestamp       not null,
+    KEY idx_user_id_audit_events (user_id, id),
+    KEY idx_event_audit_events (event, id),
+    KEY idx_ip_address_audit_events (ip_address, id)
+) engine innodb;
+
+INSERT INTO permissions (name, description) VALUES
+    ('audit:read', 'Query the security audit log of every user');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id FROM roles JOIN permissions
+WHERE roles.name = 'admin' AND permissions.name = 'audit:read';
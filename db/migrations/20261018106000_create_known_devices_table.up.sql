@@ -0,0 +1,12 @@
+CREATE TABLE known_devices (
+    id            int unsigned not null auto_increment primary key,
+    user_id       int unsigned not null,
+    fingerprint   char(64)     not null,
+    ip_address    varchar(45)  not null default '',
+    user_agent    varchar(512) not null default '',
+    device_label  varchar(255) not null default '',
+    first_seen_at timestamp    not null,
+    last_seen_at  timestamp    not null,
+    UNIQUE KEY uq_user_id_fingerprint_known_devices (user_id, fingerprint),
+    CONSTRAINT fk_user_id_known_devices FOREIGN KEY (user_id) REFERENCES users(id)
+) engine innodb;
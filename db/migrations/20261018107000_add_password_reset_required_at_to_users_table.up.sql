@@ -0,0 +1,2 @@
+ALTER TABLE users
+    ADD COLUMN password_reset_required_at timestamp null default null;
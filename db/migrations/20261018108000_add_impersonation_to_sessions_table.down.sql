@@ -0,0 +1,6 @@
+DELETE role_permissions FROM role_permissions JOIN permissions ON permissions.id = role_permissions.permission_id WHERE permissions.name = 'users:impersonate';
+DELETE FROM permissions WHERE name = 'users:impersonate';
+ALTER TABLE sessions
+    DROP FOREIGN KEY fk_impersonator_id_sessions,
+    DROP COLUMN impersonator_id,
+    DROP COLUMN impersonation_read_only;
\ No newline at end of file
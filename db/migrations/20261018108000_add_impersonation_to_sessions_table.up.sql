@@ -0,0 +1,11 @@
+ALTER TABLE sessions
+    ADD COLUMN impersonator_id         int unsigned null default null,
+    ADD COLUMN impersonation_read_only boolean      not null default false,
+    ADD CONSTRAINT fk_impersonator_id_sessions FOREIGN KEY (impersonator_id) REFERENCES users(id);
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:impersonate', 'Sign in as another user for support');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id FROM roles JOIN permissions
+WHERE roles.name = 'admin' AND permissions.name = 'users:impersonate';
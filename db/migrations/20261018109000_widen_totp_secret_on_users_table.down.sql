@@ -0,0 +1,8 @@
+-- encrypted secrets do not fit the old column and cannot be read without the
+-- keyring, so two-factor authentication is turned off for those users
+DELETE FROM recovery_codes WHERE user_id IN (SELECT id FROM users WHERE totp_secret LIKE 'enc:%');
+
+UPDATE users SET totp_secret = '', totp_enabled_at = NULL WHERE totp_secret LIKE 'enc:%';
+
+ALTER TABLE users
+    MODIFY COLUMN totp_secret varchar(64) not null default '';
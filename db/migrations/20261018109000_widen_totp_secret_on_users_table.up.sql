@@ -0,0 +1,2 @@
+ALTER TABLE users
+    MODIFY COLUMN totp_secret varchar(255) not null default '';
@@ -73,6 +73,10 @@ func handleValidationErrorMessage(tag string, param string, field string) string
 		msg = fmt.Sprintf("The %s field must be at least %s characters", strings.ToLower(field), param)
 	case "max":
 		msg = fmt.Sprintf("The %s field must be at most %s characters", strings.ToLower(field), param)
+	case "oneof":
+		msg = fmt.Sprintf("The %s field must be one of: %s", strings.ToLower(field), strings.ReplaceAll(param, " ", ", "))
+	case "datetime":
+		msg = fmt.Sprintf("The %s field must be a date in the format %s", strings.ToLower(field), param)
 	case "eqfield":
 		if param == "Password" {
 			msg = "The password confirmation does not match"
@@ -83,3 +87,27 @@ func handleValidationErrorMessage(tag string, param string, field string) string
 
 	return msg
 }
+
+func handleListErrorMessage(tag string, param string, field string) string {
+	field = strings.Replace(field, "_", " ", -1)
+	switch tag {
+	case "min":
+		return fmt.Sprintf("The %s field must have at least %s entries", field, param)
+	case "max":
+		return fmt.Sprintf("The %s field must have at most %s entries", field, param)
+	}
+
+	return handleValidationErrorMessage(tag, param, field)
+}
+
+func handleListEntryErrorMessage(tag string, param string, field string) string {
+	field = strings.Replace(field, "_", " ", -1)
+	switch tag {
+	case "required":
+		return fmt.Sprintf("The %s field must not contain empty entries", field)
+	case "max":
+		return fmt.Sprintf("Every entry of the %s field must be at most %s characters", field, param)
+	}
+
+	return fmt.Sprintf("The %s field contains an invalid entry", field)
+}
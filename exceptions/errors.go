@@ -4,6 +4,7 @@ import (
 	"github.com/go-playground/validator/v10"
 	"net/http"
 	"reflect"
+	"strings"
 )
 
 type GlobalError interface {
@@ -125,6 +126,23 @@ func NewHttpNotFoundError(msg string) HttpNotFoundError {
 	return HttpNotFoundError{Msg: msg, Code: http.StatusNotFound}
 }
 
+type HttpTooManyRequestsError struct {
+	Msg  string
+	Code int
+}
+
+func (t HttpTooManyRequestsError) Error() string {
+	return t.Msg
+}
+
+func (t HttpTooManyRequestsError) GetCode() int {
+	return t.Code
+}
+
+func NewTooManyRequestsError(msg string) HttpTooManyRequestsError {
+	return HttpTooManyRequestsError{Msg: msg, Code: http.StatusTooManyRequests}
+}
+
 type FailedValidationError struct {
 	Msg    string
 	Code   int
@@ -151,10 +169,37 @@ func NewFailedValidationError(obj interface{}, err validator.ValidationErrors) F
 	}
 
 	for _, err := range err {
-		structField, _ := objRef.FieldByName(err.Field())
+		// entries of a list are reported as Name[i], the error belongs to
+		// the list field
+		name, _, isEntry := strings.Cut(err.Field(), "[")
+		structField, _ := objRef.FieldByName(name)
 		field := structField.Tag.Get("json")
-		errMsgs[field] = handleValidationErrorMessage(err.Tag(), err.Param(), field)
+
+		switch {
+		case isEntry:
+			errMsgs[field] = handleListEntryErrorMessage(err.Tag(), err.Param(), field)
+		case err.Kind() == reflect.Slice:
+			errMsgs[field] = handleListErrorMessage(err.Tag(), err.Param(), field)
+		default:
+			errMsgs[field] = handleValidationErrorMessage(err.Tag(), err.Param(), field)
+		}
+	}
+
+	return FailedValidationError{Msg: "Failed Validation", Code: http.StatusUnprocessableEntity, Errors: errMsgs}
+}
+
+// NewFieldValidationError reports a single field that failed checks done
+// outside the validator, in the same shape as NewFailedValidationError. The
+// field holds every message so all problems can be fixed at once.
+func NewFieldValidationError(obj interface{}, field string, msgs []string) FailedValidationError {
+	objRef := reflect.TypeOf(obj)
+
+	errMsgs := make(map[string]interface{})
+
+	for i := 0; i < objRef.NumField(); i++ {
+		errMsgs[objRef.Field(i).Tag.Get("json")] = nil
 	}
+	errMsgs[field] = msgs
 
 	return FailedValidationError{Msg: "Failed Validation", Code: http.StatusUnprocessableEntity, Errors: errMsgs}
 }
@@ -0,0 +1,43 @@
+package exceptions
+
+import (
+	"github.com/go-playground/validator/v10"
+	"testing"
+)
+
+type listRequest struct {
+	Name      string   `json:"name" validate:"required"`
+	Allergies []string `json:"allergies" validate:"max=2,dive,required,max=5"`
+}
+
+func TestNewFailedValidationErrorReportsListFields(t *testing.T) {
+	tests := []struct {
+		name string
+		req  listRequest
+		want string
+	}{
+		{name: "empty entry", req: listRequest{Name: "a", Allergies: []string{"nuts", ""}}, want: "The allergies field must not contain empty entries"},
+		{name: "long entry", req: listRequest{Name: "a", Allergies: []string{"peanuts"}}, want: "Every entry of the allergies field must be at most 5 characters"},
+		{name: "too many entries", req: listRequest{Name: "a", Allergies: []string{"a", "b", "c"}}, want: "The allergies field must have at most 2 entries"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validator.New().Struct(test.req)
+			if err == nil {
+				t.Fatal("Struct() accepted the request")
+			}
+
+			failed := NewFailedValidationError(test.req, err.(validator.ValidationErrors))
+			if _, ok := failed.Errors[""]; ok {
+				t.Fatalf("Errors has an empty key: %v", failed.Errors)
+			}
+			if got := failed.Errors["allergies"]; got != test.want {
+				t.Fatalf("Errors[allergies] = %v, want %q", got, test.want)
+			}
+			if failed.Errors["name"] != nil {
+				t.Fatalf("Errors[name] = %v, want nil", failed.Errors["name"])
+			}
+		})
+	}
+}
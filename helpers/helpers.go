@@ -2,82 +2,65 @@ package helpers
 
 import (
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
+	cryptoRand "crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/google/generative-ai-go/genai"
-	"golang.org/x/crypto/bcrypt"
 	"log"
-	"math/rand"
+	"math/big"
 	"mime/multipart"
-	"time"
+	"net/url"
+	"strings"
 )
 
-func HashPassword(pass string) (string, error) {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
-	if err != nil {
+func GenerateRandomToken(length int) (string, error) {
+	b := make([]byte, length)
+	if _, err := cryptoRand.Read(b); err != nil {
 		return "", err
 	}
 
-	return string(hashed), nil
-}
-
-func VerifyPassword(pass string, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass))
-	return err == nil
-}
-
-func StringToBase64(s []byte) string {
-	return base64.StdEncoding.EncodeToString(s)
-}
-
-func Base64ToString(s string) string {
-	b, _ := base64.StdEncoding.DecodeString(s)
-
-	return string(b)
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-var bytes = []byte{35, 46, 57, 24, 85, 35, 24, 74, 87, 35, 88, 98, 66, 32, 14, 05}
-
-func Encrypt(text, MySecret string) (string, error) {
-	block, err := aes.NewCipher([]byte(MySecret))
-	if err != nil {
-		return "", err
-	}
-	plainText := []byte(text)
-	cfb := cipher.NewCFBEncrypter(block, bytes)
-	cipherText := make([]byte, len(plainText))
-	cfb.XORKeyStream(cipherText, plainText)
-	return StringToBase64(cipherText), nil
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-func Decrypt(text, MySecret string) (string, error) {
-	block, err := aes.NewCipher([]byte(MySecret))
-	if err != nil {
-		return "", err
+// NonNilStrings makes empty lists encode as [] rather than null.
+func NonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
 	}
-	cipherText := Base64ToString(text)
-	cfb := cipher.NewCFBDecrypter(block, bytes)
-	plainText := make([]byte, len(cipherText))
-	cfb.XORKeyStream(plainText, []byte(cipherText))
-	return string(plainText), nil
+	return values
 }
 
-func UploadToGemini(ctx context.Context, client *genai.Client, file multipart.File, mimeType string) (string, error) {
+func UploadToGemini(ctx context.Context, client *genai.Client, file multipart.File, mimeType string) (*genai.File, error) {
 	options := genai.UploadFileOptions{
 		DisplayName: "uploaded-image",
 		MIMEType:    mimeType,
 	}
 	fileData, err := client.UploadFile(ctx, "", file, &options)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	log.Printf("Uploaded file %s as: %s", fileData.DisplayName, fileData.URI)
-	return fileData.URI, nil
+	return fileData, nil
+}
+
+func DeleteFromGemini(ctx context.Context, client *genai.Client, name string) error {
+	err := client.DeleteFile(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Deleted gemini file %s", name)
+	return nil
 }
 
 func UploadS3(ctx context.Context, uploader *manager.Uploader, file multipart.File, fileName string, bucket string) (string, error) {
@@ -95,12 +78,90 @@ func UploadS3(ctx context.Context, uploader *manager.Uploader, file multipart.Fi
 	return uploadedFile.Location, nil
 }
 
-func GenerateRandomCodeForOtp() string {
-	rand.Seed(time.Now().UnixNano())
-	var letters = []rune("0123456789")
-	b := make([]rune, 4)
+func DeleteS3(ctx context.Context, client *s3.Client, key string, bucket string) error {
+	_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Deleted s3 object %s", key)
+	return nil
+}
+
+// S3KeyFromLocation extracts the object key from a location returned by
+// UploadS3, supporting both virtual-hosted and path style urls.
+func S3KeyFromLocation(location string, bucket string) (string, error) {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+
+	key := strings.TrimPrefix(parsed.Path, "/")
+	if !strings.HasPrefix(parsed.Host, bucket+".") {
+		key = strings.TrimPrefix(key, bucket+"/")
+	}
+
+	return key, nil
+}
+
+func GenerateNumericCode(length int) (string, error) {
+	b := make([]byte, length)
 	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+		n, err := cryptoRand.Int(cryptoRand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		b[i] = byte('0' + n.Int64())
 	}
-	return string(b)
+
+	return string(b), nil
+}
+
+// DeviceFingerprint identifies a device by the IP address and user agent it
+// signs in with.
+func DeviceFingerprint(ipAddress string, userAgent string) string {
+	return HashToken(ipAddress + "\n" + userAgent)
+}
+
+func DeviceLabel(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	ua := strings.ToLower(userAgent)
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(ua, "okhttp") || strings.Contains(ua, "dart"):
+		browser = "Evia App"
+	case strings.Contains(ua, "edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "opr/") || strings.Contains(ua, "opera"):
+		browser = "Opera"
+	case strings.Contains(ua, "firefox"):
+		browser = "Firefox"
+	case strings.Contains(ua, "chrome"):
+		browser = "Chrome"
+	case strings.Contains(ua, "safari"):
+		browser = "Safari"
+	}
+
+	platform := "Unknown OS"
+	switch {
+	case strings.Contains(ua, "android"):
+		platform = "Android"
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad"):
+		platform = "iOS"
+	case strings.Contains(ua, "windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "mac os"):
+		platform = "macOS"
+	case strings.Contains(ua, "linux"):
+		platform = "Linux"
+	}
+
+	return browser + " on " + platform
 }
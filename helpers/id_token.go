@@ -0,0 +1,264 @@
+package helpers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/sync/singleflight"
+	"math/big"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJwksTtl     = time.Hour
+	jwksRefreshBackoff = time.Minute
+	idTokenClockSkew   = time.Minute
+)
+
+var ErrInvalidIdToken = errors.New("invalid id token")
+
+var maxAgePattern = regexp.MustCompile(`max-age=(\d+)`)
+
+type IdTokenClaims struct {
+	Issuer        string       `json:"iss"`
+	Subject       string       `json:"sub"`
+	Audience      jsonAudience `json:"aud"`
+	IssuedAt      int64        `json:"iat"`
+	ExpiresAt     int64        `json:"exp"`
+	Nonce         string       `json:"nonce"`
+	Email         string       `json:"email"`
+	EmailVerified jsonBool     `json:"email_verified"`
+	Name          string       `json:"name"`
+	Picture       string       `json:"picture"`
+}
+
+// jsonAudience accepts both the single string and the array form of aud.
+type jsonAudience []string
+
+func (a *jsonAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = []string{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// jsonBool accepts providers that send email_verified as "true".
+type jsonBool bool
+
+func (b *jsonBool) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseBool(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*b = jsonBool(value)
+	return nil
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JwksCache keeps the signing keys of an identity provider in memory. Keys
+// are refreshed when the cache expires (following Cache-Control max-age) or
+// when a token names a kid that is not known yet, at most once a minute.
+// Concurrent lookups share a single fetch, made without holding the lock.
+type JwksCache struct {
+	Url        string
+	HttpClient *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	expiresAt   time.Time
+	lastFetchAt time.Time
+	fetches     singleflight.Group
+}
+
+func NewJwksCache(url string, httpClient *http.Client) *JwksCache {
+	return &JwksCache{Url: url, HttpClient: httpClient}
+}
+
+func (j *JwksCache) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	j.mu.Lock()
+	key, known := j.keys[kid]
+	fresh := time.Now().Before(j.expiresAt)
+	j.mu.Unlock()
+
+	if known && fresh {
+		return key, nil
+	}
+
+	// the fetch is shared, so one caller going away must not cancel it for
+	// the others
+	_, err, _ := j.fetches.Do("jwks", func() (any, error) {
+		return nil, j.refresh(context.WithoutCancel(ctx))
+	})
+
+	j.mu.Lock()
+	key, known = j.keys[kid]
+	j.mu.Unlock()
+
+	if err != nil && !known {
+		return nil, err
+	}
+
+	if !known {
+		return nil, ErrInvalidIdToken
+	}
+
+	return key, nil
+}
+
+// refresh fetches the keys unless they were fetched within the backoff.
+func (j *JwksCache) refresh(ctx context.Context) error {
+	j.mu.Lock()
+	if time.Since(j.lastFetchAt) < jwksRefreshBackoff {
+		j.mu.Unlock()
+		return nil
+	}
+	j.lastFetchAt = time.Now()
+	j.mu.Unlock()
+
+	keys, ttl, err := j.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.expiresAt = time.Now().Add(ttl)
+	j.mu.Unlock()
+
+	return nil
+}
+
+func (j *JwksCache) fetch(ctx context.Context) (map[string]*rsa.PublicKey, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.Url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := j.HttpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("jwks endpoint returned %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, 0, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(body.Keys))
+	for _, jwk := range body.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			continue
+		}
+
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	ttl := defaultJwksTtl
+	if match := maxAgePattern.FindStringSubmatch(resp.Header.Get("Cache-Control")); match != nil {
+		if seconds, err := strconv.Atoi(match[1]); err == nil {
+			ttl = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return keys, ttl, nil
+}
+
+// VerifyIdToken checks the RS256 signature of an OpenID Connect ID token
+// against the provider's JWKS, then its issuer, audience and expiry.
+func VerifyIdToken(ctx context.Context, jwks *JwksCache, token string, audience string, issuers []string) (*IdTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIdToken
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidIdToken
+	}
+
+	var header tokenHeader
+	if err := json.Unmarshal(rawHeader, &header); err != nil || header.Alg != "RS256" {
+		return nil, ErrInvalidIdToken
+	}
+
+	key, err := jwks.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIdToken
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+		return nil, ErrInvalidIdToken
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidIdToken
+	}
+
+	var claims IdTokenClaims
+	if err := json.Unmarshal(rawClaims, &claims); err != nil {
+		return nil, ErrInvalidIdToken
+	}
+
+	now := time.Now()
+	if !slices.Contains(issuers, claims.Issuer) ||
+		!slices.Contains(claims.Audience, audience) ||
+		claims.Subject == "" ||
+		now.After(time.Unix(claims.ExpiresAt, 0).Add(idTokenClockSkew)) ||
+		now.Before(time.Unix(claims.IssuedAt, 0).Add(-idTokenClockSkew)) {
+		return nil, ErrInvalidIdToken
+	}
+
+	return &claims, nil
+}
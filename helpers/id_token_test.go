@@ -0,0 +1,349 @@
+package helpers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	fakeClientId = "client-id"
+	fakeSubject  = "subject-1"
+)
+
+// fakeProvider is a local OpenID Connect provider serving a discovery
+// document and the public keys of the signing keys it holds.
+type fakeProvider struct {
+	server      *httptest.Server
+	jwksFetches atomic.Int32
+	jwksDelay   time.Duration
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PrivateKey
+}
+
+func newFakeProvider(t *testing.T, kids ...string) *fakeProvider {
+	t.Helper()
+
+	provider := &fakeProvider{keys: make(map[string]*rsa.PrivateKey)}
+	for _, kid := range kids {
+		provider.addKey(t, kid)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(OidcDiscovery{
+			Issuer:                provider.server.URL,
+			AuthorizationEndpoint: provider.server.URL + "/authorize",
+			TokenEndpoint:         provider.server.URL + "/token",
+			JwksUri:               provider.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		provider.jwksFetches.Add(1)
+		time.Sleep(provider.jwksDelay)
+
+		provider.mu.Lock()
+		keys := make([]jsonWebKey, 0, len(provider.keys))
+		for kid, key := range provider.keys {
+			keys = append(keys, jsonWebKey{
+				Kid: kid,
+				Kty: "RSA",
+				Alg: "RS256",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		provider.mu.Unlock()
+
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+
+	return provider
+}
+
+func (p *fakeProvider) addKey(t *testing.T, kid string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.mu.Lock()
+	p.keys[kid] = key
+	p.mu.Unlock()
+}
+
+func (p *fakeProvider) claims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":            p.server.URL,
+		"sub":            fakeSubject,
+		"aud":            fakeClientId,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          "nonce",
+		"email":          "user@example.com",
+		"email_verified": true,
+	}
+}
+
+func (p *fakeProvider) sign(t *testing.T, kid string, claims map[string]any) string {
+	t.Helper()
+
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if !ok {
+		// a key the provider never published
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (p *fakeProvider) jwks() *JwksCache {
+	return NewJwksCache(p.server.URL+"/jwks", p.server.Client())
+}
+
+func TestVerifyIdToken(t *testing.T) {
+	provider := newFakeProvider(t, "key-1")
+	issuers := []string{provider.server.URL}
+
+	tests := []struct {
+		name   string
+		token  func() string
+		wantOk bool
+	}{
+		{
+			name:   "valid",
+			token:  func() string { return provider.sign(t, "key-1", provider.claims()) },
+			wantOk: true,
+		},
+		{
+			name: "audience as array",
+			token: func() string {
+				claims := provider.claims()
+				claims["aud"] = []string{"other", fakeClientId}
+				return provider.sign(t, "key-1", claims)
+			},
+			wantOk: true,
+		},
+		{
+			name: "other audience",
+			token: func() string {
+				claims := provider.claims()
+				claims["aud"] = "other"
+				return provider.sign(t, "key-1", claims)
+			},
+		},
+		{
+			name: "other issuer",
+			token: func() string {
+				claims := provider.claims()
+				claims["iss"] = "https://evil.example"
+				return provider.sign(t, "key-1", claims)
+			},
+		},
+		{
+			name: "expired",
+			token: func() string {
+				claims := provider.claims()
+				claims["exp"] = time.Now().Add(-time.Hour).Unix()
+				return provider.sign(t, "key-1", claims)
+			},
+		},
+		{
+			name: "issued in the future",
+			token: func() string {
+				claims := provider.claims()
+				claims["iat"] = time.Now().Add(time.Hour).Unix()
+				return provider.sign(t, "key-1", claims)
+			},
+		},
+		{
+			name: "missing subject",
+			token: func() string {
+				claims := provider.claims()
+				delete(claims, "sub")
+				return provider.sign(t, "key-1", claims)
+			},
+		},
+		{
+			name:  "signed with an unpublished key",
+			token: func() string { return provider.sign(t, "unknown", provider.claims()) },
+		},
+		{
+			name: "tampered payload",
+			token: func() string {
+				parts := strings.Split(provider.sign(t, "key-1", provider.claims()), ".")
+				claims := provider.claims()
+				claims["sub"] = "someone-else"
+				payload, _ := json.Marshal(claims)
+				return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+			},
+		},
+		{
+			name:  "malformed",
+			token: func() string { return "not-a-token" },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := VerifyIdToken(context.Background(), provider.jwks(), test.token(), fakeClientId, issuers)
+			if test.wantOk {
+				if err != nil {
+					t.Fatalf("VerifyIdToken() error = %v", err)
+				}
+				if claims.Subject != fakeSubject || claims.Email != "user@example.com" || !bool(claims.EmailVerified) {
+					t.Fatalf("VerifyIdToken() claims = %+v", claims)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("VerifyIdToken() accepted the token")
+			}
+		})
+	}
+}
+
+func TestVerifyIdTokenRejectsOtherAlgorithms(t *testing.T) {
+	provider := newFakeProvider(t, "key-1")
+
+	header, _ := json.Marshal(map[string]string{"alg": "none", "kid": "key-1"})
+	payload, _ := json.Marshal(provider.claims())
+	token := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+
+	_, err := VerifyIdToken(context.Background(), provider.jwks(), token, fakeClientId, []string{provider.server.URL})
+	if !errors.Is(err, ErrInvalidIdToken) {
+		t.Fatalf("VerifyIdToken() error = %v, want %v", err, ErrInvalidIdToken)
+	}
+	if provider.jwksFetches.Load() != 0 {
+		t.Fatal("VerifyIdToken() fetched keys for an unsigned token")
+	}
+}
+
+func TestJwksCacheFetchesRotatedKeys(t *testing.T) {
+	provider := newFakeProvider(t, "key-1")
+	jwks := provider.jwks()
+	issuers := []string{provider.server.URL}
+
+	for i := 0; i < 3; i++ {
+		_, err := VerifyIdToken(context.Background(), jwks, provider.sign(t, "key-1", provider.claims()), fakeClientId, issuers)
+		if err != nil {
+			t.Fatalf("VerifyIdToken() error = %v", err)
+		}
+	}
+	if fetches := provider.jwksFetches.Load(); fetches != 1 {
+		t.Fatalf("jwks fetched %d times, want 1", fetches)
+	}
+
+	// a kid that is not cached yet triggers a refetch, once the backoff passed
+	provider.addKey(t, "key-2")
+	jwks.mu.Lock()
+	jwks.lastFetchAt = time.Now().Add(-jwksRefreshBackoff)
+	jwks.mu.Unlock()
+
+	_, err := VerifyIdToken(context.Background(), jwks, provider.sign(t, "key-2", provider.claims()), fakeClientId, issuers)
+	if err != nil {
+		t.Fatalf("VerifyIdToken() with rotated key error = %v", err)
+	}
+	if fetches := provider.jwksFetches.Load(); fetches != 2 {
+		t.Fatalf("jwks fetched %d times, want 2", fetches)
+	}
+
+	// unknown kids within the backoff do not reach the provider
+	_, err = VerifyIdToken(context.Background(), jwks, provider.sign(t, "unknown", provider.claims()), fakeClientId, issuers)
+	if !errors.Is(err, ErrInvalidIdToken) {
+		t.Fatalf("VerifyIdToken() error = %v, want %v", err, ErrInvalidIdToken)
+	}
+	if fetches := provider.jwksFetches.Load(); fetches != 2 {
+		t.Fatalf("jwks fetched %d times, want 2", fetches)
+	}
+}
+
+func TestJwksCacheSharesConcurrentFetches(t *testing.T) {
+	provider := newFakeProvider(t, "key-1")
+	provider.jwksDelay = 100 * time.Millisecond
+	jwks := provider.jwks()
+	token := provider.sign(t, "key-1", provider.claims())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := VerifyIdToken(context.Background(), jwks, token, fakeClientId, []string{provider.server.URL})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("VerifyIdToken() error = %v", err)
+		}
+	}
+	if fetches := provider.jwksFetches.Load(); fetches != 1 {
+		t.Fatalf("jwks fetched %d times, want 1", fetches)
+	}
+}
+
+func TestFetchOidcDiscovery(t *testing.T) {
+	provider := newFakeProvider(t)
+
+	discovery, err := FetchOidcDiscovery(context.Background(), provider.server.Client(), provider.server.URL+"/")
+	if err != nil {
+		t.Fatalf("FetchOidcDiscovery() error = %v", err)
+	}
+	if discovery.JwksUri != provider.server.URL+"/jwks" || discovery.TokenEndpoint != provider.server.URL+"/token" {
+		t.Fatalf("FetchOidcDiscovery() = %+v", discovery)
+	}
+
+	// a document naming another issuer must not be trusted
+	impostor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(OidcDiscovery{Issuer: provider.server.URL, JwksUri: provider.server.URL + "/jwks"})
+	}))
+	defer impostor.Close()
+
+	_, err = FetchOidcDiscovery(context.Background(), impostor.Client(), impostor.URL)
+	if err == nil {
+		t.Fatal("FetchOidcDiscovery() accepted a document of another issuer")
+	}
+}
@@ -0,0 +1,47 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type OidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// FetchOidcDiscovery loads the provider's openid-configuration document and
+// checks that it belongs to the expected issuer.
+func FetchOidcDiscovery(ctx context.Context, httpClient *http.Client, issuer string) (*OidcDiscovery, error) {
+	url := strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery endpoint returned %d", resp.StatusCode)
+	}
+
+	var discovery OidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimRight(discovery.Issuer, "/") != strings.TrimRight(issuer, "/") {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", discovery.Issuer, issuer)
+	}
+
+	return &discovery, nil
+}
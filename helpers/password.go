@@ -0,0 +1,212 @@
+package helpers
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	cryptoRand "crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"strings"
+)
+
+const (
+	Argon2idAlgorithm = "argon2id"
+	BcryptAlgorithm   = "bcrypt"
+)
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+// PasswordHasher hashes passwords into self describing strings that carry the
+// algorithm and its cost parameters, so the parameters can change without
+// breaking hashes stored earlier.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password string, hash string) bool
+	// Recognizes reports whether the hash was produced by this algorithm.
+	Recognizes(hash string) bool
+	// NeedsRehash reports whether the hash was made with other parameters than
+	// the hasher currently uses.
+	NeedsRehash(hash string) bool
+}
+
+// Argon2idHasher stores hashes in the PHC string format,
+// $argon2id$v=19$m=<memory KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>.
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type argon2idHash struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (a Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := cryptoRand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2idAlgorithm, argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2idHasher) Verify(password string, hash string) bool {
+	parsed, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false
+	}
+
+	key := argon2.IDKey([]byte(password), parsed.salt, parsed.iterations, parsed.memory, parsed.parallelism, uint32(len(parsed.key)))
+	return subtle.ConstantTimeCompare(key, parsed.key) == 1
+}
+
+func (a Argon2idHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$"+Argon2idAlgorithm+"$")
+}
+
+func (a Argon2idHasher) NeedsRehash(hash string) bool {
+	parsed, err := parseArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+
+	return parsed.memory != a.Memory || parsed.iterations != a.Iterations || parsed.parallelism != a.Parallelism ||
+		uint32(len(parsed.salt)) != a.SaltLength || uint32(len(parsed.key)) != a.KeyLength
+}
+
+func parseArgon2idHash(hash string) (*argon2idHash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != Argon2idAlgorithm {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	var parsed argon2idHash
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &parsed.memory, &parsed.iterations, &parsed.parallelism)
+	if err != nil {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	parsed.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	parsed.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(parsed.key) == 0 {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	return &parsed, nil
+}
+
+// BcryptHasher covers the hashes stored before argon2id became the default.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (b BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hashed), nil
+}
+
+func (b BcryptHasher) Verify(password string, hash string) bool {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
+}
+
+func (b BcryptHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (b BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.Cost
+}
+
+// MultiHasher hashes new passwords with its first hasher and verifies stored
+// hashes with whichever hasher recognizes them. Hashes from any hasher but the
+// first need a rehash.
+type MultiHasher struct {
+	Hashers []PasswordHasher
+}
+
+// NewPasswordHasher builds the hasher set up by PASSWORD_HASH_ALGORITHM and the
+// cost settings, keeping the other algorithm around for existing hashes.
+func NewPasswordHasher(cnf *config.Config) *MultiHasher {
+	argon2id := Argon2idHasher{
+		Memory:      cnf.Env.GetUint32("PASSWORD_ARGON2_MEMORY"),
+		Iterations:  cnf.Env.GetUint32("PASSWORD_ARGON2_ITERATIONS"),
+		Parallelism: uint8(cnf.Env.GetUint("PASSWORD_ARGON2_PARALLELISM")),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	bcryptHasher := BcryptHasher{Cost: cnf.Env.GetInt("PASSWORD_BCRYPT_COST")}
+
+	if argon2id.Memory == 0 || argon2id.Iterations == 0 || argon2id.Parallelism == 0 {
+		log.Fatal("PASSWORD_ARGON2_MEMORY, PASSWORD_ARGON2_ITERATIONS and PASSWORD_ARGON2_PARALLELISM must be positive")
+	}
+
+	if bcryptHasher.Cost < bcrypt.MinCost || bcryptHasher.Cost > bcrypt.MaxCost {
+		log.Fatalf("PASSWORD_BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	switch algorithm := cnf.Env.GetString("PASSWORD_HASH_ALGORITHM"); algorithm {
+	case Argon2idAlgorithm:
+		return &MultiHasher{Hashers: []PasswordHasher{argon2id, bcryptHasher}}
+	case BcryptAlgorithm:
+		return &MultiHasher{Hashers: []PasswordHasher{bcryptHasher, argon2id}}
+	default:
+		log.Fatalf("Unknown PASSWORD_HASH_ALGORITHM %q", algorithm)
+		return nil
+	}
+}
+
+func (m *MultiHasher) Hash(password string) (string, error) {
+	return m.Hashers[0].Hash(password)
+}
+
+func (m *MultiHasher) Verify(password string, hash string) bool {
+	for _, hasher := range m.Hashers {
+		if hasher.Recognizes(hash) {
+			return hasher.Verify(password, hash)
+		}
+	}
+
+	return false
+}
+
+func (m *MultiHasher) Recognizes(hash string) bool {
+	for _, hasher := range m.Hashers {
+		if hasher.Recognizes(hash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *MultiHasher) NeedsRehash(hash string) bool {
+	return !m.Hashers[0].Recognizes(hash) || m.Hashers[0].NeedsRehash(hash)
+}
@@ -0,0 +1,196 @@
+package helpers
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"fmt"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+// cheap parameters keep the tests fast, they are not meant for production
+var testArgon2id = Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+var testBcrypt = BcryptHasher{Cost: bcrypt.MinCost}
+
+func TestArgon2idHasher(t *testing.T) {
+	hash, err := testArgon2id.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantPrefix := fmt.Sprintf("$argon2id$v=%d$m=64,t=1,p=1$", argon2.Version)
+	if !strings.HasPrefix(hash, wantPrefix) {
+		t.Fatalf("Hash() = %q, want prefix %q", hash, wantPrefix)
+	}
+
+	if !testArgon2id.Recognizes(hash) || testBcrypt.Recognizes(hash) {
+		t.Fatal("Recognizes() does not tell the algorithms apart")
+	}
+
+	if !testArgon2id.Verify("correct horse battery staple", hash) {
+		t.Fatal("Verify() rejected the password")
+	}
+	if testArgon2id.Verify("wrong password", hash) {
+		t.Fatal("Verify() accepted a wrong password")
+	}
+
+	other, err := testArgon2id.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == hash {
+		t.Fatal("Hash() reused the salt")
+	}
+}
+
+func TestParseArgon2idHash(t *testing.T) {
+	hash, err := testArgon2id.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := parseArgon2idHash(hash)
+	if err != nil {
+		t.Fatalf("parseArgon2idHash() error = %v", err)
+	}
+	if parsed.memory != 64 || parsed.iterations != 1 || parsed.parallelism != 1 || len(parsed.salt) != 16 || len(parsed.key) != 32 {
+		t.Fatalf("parseArgon2idHash() = %+v", parsed)
+	}
+
+	parts := strings.Split(hash, "$")
+	invalid := map[string]string{
+		"empty":          "",
+		"bcrypt":         "$2a$10$abcdefghijklmnopqrstuuABCDEFGHIJKLMNOPQRSTUVWXYZ01234",
+		"argon2i":        strings.Replace(hash, "$argon2id$", "$argon2i$", 1),
+		"missing part":   strings.Join(parts[:5], "$"),
+		"other version":  strings.Replace(hash, fmt.Sprintf("v=%d", argon2.Version), "v=16", 1),
+		"bad parameters": strings.Replace(hash, "m=64,t=1,p=1", "m=x,t=1,p=1", 1),
+		"bad salt":       strings.Join([]string{parts[0], parts[1], parts[2], parts[3], "!!", parts[5]}, "$"),
+		"empty key":      strings.Join([]string{parts[0], parts[1], parts[2], parts[3], parts[4], ""}, "$"),
+	}
+	for name, hash := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseArgon2idHash(hash); err != ErrInvalidPasswordHash {
+				t.Fatalf("parseArgon2idHash() error = %v, want %v", err, ErrInvalidPasswordHash)
+			}
+			if testArgon2id.Verify("password", hash) {
+				t.Fatal("Verify() accepted an invalid hash")
+			}
+			if !testArgon2id.NeedsRehash(hash) {
+				t.Fatal("NeedsRehash() = false for an invalid hash")
+			}
+		})
+	}
+}
+
+func TestArgon2idHasherNeedsRehash(t *testing.T) {
+	hash, err := testArgon2id.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if testArgon2id.NeedsRehash(hash) {
+		t.Fatal("NeedsRehash() = true for current parameters")
+	}
+
+	changed := map[string]Argon2idHasher{
+		"memory":      {Memory: 128, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		"iterations":  {Memory: 64, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		"parallelism": {Memory: 64, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32},
+		"salt length": {Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 32, KeyLength: 32},
+		"key length":  {Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 64},
+	}
+	for name, hasher := range changed {
+		t.Run(name, func(t *testing.T) {
+			if !hasher.NeedsRehash(hash) {
+				t.Fatal("NeedsRehash() = false after the parameters changed")
+			}
+			// the old parameters still verify
+			if !hasher.Verify("password", hash) {
+				t.Fatal("Verify() rejected a hash with other parameters")
+			}
+		})
+	}
+}
+
+func TestBcryptHasher(t *testing.T) {
+	hash, err := testBcrypt.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !testBcrypt.Recognizes(hash) || testArgon2id.Recognizes(hash) {
+		t.Fatal("Recognizes() does not tell the algorithms apart")
+	}
+	if !testBcrypt.Verify("password", hash) || testBcrypt.Verify("wrong password", hash) {
+		t.Fatal("Verify() did not check the password")
+	}
+	if testBcrypt.NeedsRehash(hash) {
+		t.Fatal("NeedsRehash() = true for the current cost")
+	}
+	if !(BcryptHasher{Cost: bcrypt.MinCost + 1}).NeedsRehash(hash) {
+		t.Fatal("NeedsRehash() = false after the cost changed")
+	}
+}
+
+func TestMultiHasher(t *testing.T) {
+	hasher := &MultiHasher{Hashers: []PasswordHasher{testArgon2id, testBcrypt}}
+
+	hash, err := hasher.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !testArgon2id.Recognizes(hash) {
+		t.Fatalf("Hash() = %q, want an argon2id hash", hash)
+	}
+	if !hasher.Verify("password", hash) || hasher.NeedsRehash(hash) {
+		t.Fatal("preferred hash not verified or marked for rehash")
+	}
+
+	legacy, err := testBcrypt.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasher.Verify("password", legacy) || hasher.Verify("wrong password", legacy) {
+		t.Fatal("Verify() did not check the bcrypt hash")
+	}
+	if !hasher.NeedsRehash(legacy) {
+		t.Fatal("NeedsRehash() = false for a hash of another algorithm")
+	}
+
+	for _, unknown := range []string{"", "plain text", "$unknown$hash"} {
+		if hasher.Recognizes(unknown) || hasher.Verify(unknown, unknown) || !hasher.NeedsRehash(unknown) {
+			t.Fatalf("unknown hash %q was treated as valid", unknown)
+		}
+	}
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+	for _, algorithm := range []string{Argon2idAlgorithm, BcryptAlgorithm} {
+		t.Run(algorithm, func(t *testing.T) {
+			env := viper.New()
+			env.Set("PASSWORD_HASH_ALGORITHM", algorithm)
+			env.Set("PASSWORD_ARGON2_MEMORY", 64)
+			env.Set("PASSWORD_ARGON2_ITERATIONS", 1)
+			env.Set("PASSWORD_ARGON2_PARALLELISM", 1)
+			env.Set("PASSWORD_BCRYPT_COST", bcrypt.MinCost)
+
+			hasher := NewPasswordHasher(&config.Config{Env: env})
+
+			hash, err := hasher.Hash("password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if algorithm == Argon2idAlgorithm && !testArgon2id.Recognizes(hash) ||
+				algorithm == BcryptAlgorithm && !testBcrypt.Recognizes(hash) {
+				t.Fatalf("Hash() = %q, want a %s hash", hash, algorithm)
+			}
+			if hasher.NeedsRehash(hash) {
+				t.Fatal("NeedsRehash() = true for a fresh hash")
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package helpers
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestKeyring(currentKid string, keys map[string]string) *config.Keyring {
+	keyring := &config.Keyring{CurrentKid: currentKid, Keys: map[string][]byte{}, PreviousKids: map[string]bool{}}
+	for kid, key := range keys {
+		keyring.Keys[kid] = []byte(key)
+		if kid != currentKid {
+			keyring.PreviousKids[kid] = true
+		}
+	}
+
+	// the grace period of tokens has long passed
+	keyring.RotatedAt = time.Now().Add(-time.Hour)
+
+	return keyring
+}
+
+func TestSealSecret(t *testing.T) {
+	keyring := newTestKeyring("k1", map[string]string{"k1": "first-key"})
+
+	sealed, err := SealSecret(keyring, "JBSWY3DPEHPK3PXP", "totp_secret:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsSealedSecret(sealed) || !strings.HasPrefix(sealed, CurrentSecretPrefix(keyring)) {
+		t.Fatalf("SealSecret() = %q", sealed)
+	}
+	if strings.Contains(sealed, "JBSWY3DPEHPK3PXP") {
+		t.Fatal("SealSecret() leaks the secret")
+	}
+	if len(sealed) > 255 {
+		t.Fatalf("SealSecret() = %d characters, does not fit the column", len(sealed))
+	}
+
+	secret, err := OpenSecret(keyring, sealed, "totp_secret:1")
+	if err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("OpenSecret() = %q, %v", secret, err)
+	}
+
+	again, err := SealSecret(keyring, "JBSWY3DPEHPK3PXP", "totp_secret:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == sealed {
+		t.Fatal("SealSecret() reused the nonce")
+	}
+}
+
+func TestOpenSecretRejects(t *testing.T) {
+	keyring := newTestKeyring("k1", map[string]string{"k1": "first-key"})
+
+	sealed, err := SealSecret(keyring, "JBSWY3DPEHPK3PXP", "totp_secret:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := sealed[strings.LastIndex(sealed, ":")+1:]
+	tampered := []byte(payload)
+	if tampered[len(tampered)/2] == 'A' {
+		tampered[len(tampered)/2] = 'B'
+	} else {
+		tampered[len(tampered)/2] = 'A'
+	}
+
+	tests := map[string]struct {
+		value   string
+		binding string
+	}{
+		"other binding": {sealed, "totp_secret:2"},
+		"plaintext":     {"JBSWY3DPEHPK3PXP", "totp_secret:1"},
+		"unknown kid":   {"enc:k9:" + payload, "totp_secret:1"},
+		"tampered":      {"enc:k1:" + string(tampered), "totp_secret:1"},
+		"truncated":     {"enc:k1:AAAA", "totp_secret:1"},
+		"no payload":    {"enc:k1", "totp_secret:1"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := OpenSecret(keyring, test.value, test.binding); err != ErrInvalidSecret {
+				t.Fatalf("OpenSecret() error = %v, want %v", err, ErrInvalidSecret)
+			}
+		})
+	}
+}
+
+func TestOpenSecretAfterRotation(t *testing.T) {
+	before := newTestKeyring("k1", map[string]string{"k1": "first-key"})
+	sealed, err := SealSecret(before, "JBSWY3DPEHPK3PXP", "totp_secret:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// secrets are not bound to the token grace period
+	after := newTestKeyring("k2", map[string]string{"k1": "first-key", "k2": "second-key"})
+	if _, ok := after.Key("k1"); ok {
+		t.Fatal("the previous key is still valid for tokens")
+	}
+
+	secret, err := OpenSecret(after, sealed, "totp_secret:1")
+	if err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("OpenSecret() = %q, %v", secret, err)
+	}
+	if strings.HasPrefix(sealed, CurrentSecretPrefix(after)) {
+		t.Fatal("a secret sealed with the previous key counts as current")
+	}
+}
@@ -0,0 +1,111 @@
+package helpers
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	AccessTokenType             = "access"
+	ResetPasswordTokenType      = "reset_password"
+	VerifyEmailTokenType        = "verify_email"
+	TwoFactorChallengeTokenType = "two_factor_challenge"
+	UnlockAccountTokenType      = "unlock_account"
+	MagicLinkTokenType          = "magic_link"
+	ReportDeviceTokenType       = "report_device"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+type TokenClaims struct {
+	Subject   string `json:"sub"`
+	Type      string `json:"typ"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// SignToken issues an HS256 JWT signed with the current key of the keyring.
+func SignToken(keyring *config.Keyring, tokenType string, subject string, expiresAt time.Time) (string, error) {
+	header, err := json.Marshal(tokenHeader{Alg: "HS256", Typ: "JWT", Kid: keyring.CurrentKid})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(TokenClaims{
+		Subject:   subject,
+		Type:      tokenType,
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	key, _ := keyring.Key(keyring.CurrentKid)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sign(key, signingInput)), nil
+}
+
+// VerifyToken checks the signature, type and expiry of a token issued by
+// SignToken and returns its claims.
+func VerifyToken(keyring *config.Keyring, tokenType string, token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var header tokenHeader
+	if err := json.Unmarshal(rawHeader, &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := keyring.Key(header.Kid)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, sign(key, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims TokenClaims
+	if err := json.Unmarshal(rawClaims, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Type != tokenType || claims.Subject == "" || time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+func sign(key []byte, signingInput string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
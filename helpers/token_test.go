@@ -0,0 +1,75 @@
+package helpers
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyTokenAcrossKeyRotation(t *testing.T) {
+	before := &config.Keyring{
+		CurrentKid:   "2026-04",
+		Keys:         map[string][]byte{"2026-04": []byte("old-key")},
+		PreviousKids: map[string]bool{},
+	}
+	token, err := SignToken(before, AccessTokenType, "session-token", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := &config.Keyring{
+		CurrentKid:   "2026-10",
+		Keys:         map[string][]byte{"2026-10": []byte("new-key"), "2026-04": []byte("old-key")},
+		PreviousKids: map[string]bool{"2026-04": true},
+		RotatedAt:    time.Now(),
+		GracePeriod:  time.Hour,
+	}
+
+	claims, err := VerifyToken(after, AccessTokenType, token)
+	if err != nil || claims.Subject != "session-token" {
+		t.Fatalf("VerifyToken() within grace period = %+v, %v", claims, err)
+	}
+
+	after.RotatedAt = time.Now().Add(-2 * time.Hour)
+	_, err = VerifyToken(after, AccessTokenType, token)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("VerifyToken() after grace period error = %v, want %v", err, ErrInvalidToken)
+	}
+
+	// tokens signed after the rotation use the new key
+	token, err = SignToken(after, AccessTokenType, "session-token", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyToken(before, AccessTokenType, token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("VerifyToken() with the old keyring error = %v, want %v", err, ErrInvalidToken)
+	}
+	if _, err := VerifyToken(after, AccessTokenType, token); err != nil {
+		t.Fatalf("VerifyToken() error = %v", err)
+	}
+}
+
+func TestVerifyTokenChecksTypeAndExpiry(t *testing.T) {
+	keyring := &config.Keyring{
+		CurrentKid:   "default",
+		Keys:         map[string][]byte{"default": []byte("key")},
+		PreviousKids: map[string]bool{},
+	}
+
+	token, err := SignToken(keyring, ResetPasswordTokenType, "user@example.com", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyToken(keyring, AccessTokenType, token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("VerifyToken() of another type error = %v, want %v", err, ErrInvalidToken)
+	}
+
+	token, err = SignToken(keyring, AccessTokenType, "session-token", time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyToken(keyring, AccessTokenType, token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("VerifyToken() of an expired token error = %v, want %v", err, ErrInvalidToken)
+	}
+}
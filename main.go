@@ -4,14 +4,19 @@ import (
 	"akmmp241/dinamcom-2024/dinacom-go-rest/app"
 	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
 	"akmmp241/dinamcom-2024/dinacom-go-rest/controllers"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/helpers"
 	"akmmp241/dinamcom-2024/dinacom-go-rest/middleware"
 	"akmmp241/dinamcom-2024/dinacom-go-rest/repository"
 	"akmmp241/dinamcom-2024/dinacom-go-rest/service"
+	"context"
 	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"log"
 )
 
 func main() {
 	cnf := config.NewConfig()
+	keyring := config.NewKeyring(cnf)
 
 	db := app.NewDB(cnf)
 	redis := app.NewRedisClient(cnf)
@@ -25,20 +30,69 @@ func main() {
 
 	userRepo := repository.NewUserRepository()
 	sessionRepo := repository.NewSessionRepository()
+	refreshTokenRepo := repository.NewRefreshTokenRepository()
+	recoveryCodeRepo := repository.NewRecoveryCodeRepository()
 	complaintRepo := repository.NewComplaintRepository()
 	drugRepo := repository.NewDrugRepository()
+	accountDeletionRepo := repository.NewAccountDeletionRepository()
+	userProfileRepo := repository.NewUserProfileRepository()
+	userIdentityRepo := repository.NewUserIdentityRepository()
+	roleRepo := repository.NewRoleRepository()
+	apiKeyRepo := repository.NewApiKeyRepository()
+	auditEventRepo := repository.NewAuditEventRepository()
+	knownDeviceRepo := repository.NewKnownDeviceRepository()
 
-	authService := service.NewAuthService(userRepo, sessionRepo, db, validate, cnf, redis, mailer, oauthClient)
-	complaintService := service.NewComplaintService(validate, cnf, aiClient, awsClient, complaintRepo, db, drugRepo)
+	loginThrottle := service.NewLoginThrottle(redis, cnf)
+	otpStore := service.NewOtpStore(redis, cnf, keyring)
+	passwordPolicy := service.NewPasswordPolicy(cnf)
+	passwordHasher := helpers.NewPasswordHasher(cnf)
+	auditLog := service.NewAuditLog(auditEventRepo, db)
+	sessionCache := service.NewSessionCache(redis, cnf)
+	sessionLifetime := service.NewSessionLifetime(cnf)
+
+	authService := service.NewAuthService(userRepo, sessionRepo, refreshTokenRepo, recoveryCodeRepo, userIdentityRepo, roleRepo, knownDeviceRepo, db, validate, cnf, keyring, redis, mailer, oauthClient, loginThrottle, otpStore, passwordPolicy, passwordHasher, auditLog, sessionCache, sessionLifetime)
+	complaintService := service.NewComplaintService(validate, cnf, aiClient, awsClient, complaintRepo, db, drugRepo, userProfileRepo)
 	drugService := service.NewDrugService(drugRepo, db)
+	twoFactorService := service.NewTwoFactorService(userRepo, recoveryCodeRepo, db, validate, cnf, keyring, redis, sessionCache, auditLog, passwordHasher)
+	profileService := service.NewProfileService(userProfileRepo, db, validate)
+	roleService := service.NewRoleService(roleRepo, userRepo, db, validate, sessionCache, auditLog)
+	apiKeyService := service.NewApiKeyService(apiKeyRepo, db, validate, cnf, auditLog)
+	auditService := service.NewAuditService(auditEventRepo, db, validate)
+	impersonationService := service.NewImpersonationService(userRepo, sessionRepo, roleRepo, db, validate, cnf, keyring, auditLog)
+	accountDeletionService := service.NewAccountDeletionService(accountDeletionRepo, userRepo, sessionRepo, recoveryCodeRepo, userIdentityRepo, apiKeyRepo, complaintRepo, db, cnf, redis, aiClient, awsClient, sessionCache, passwordHasher, keyring)
 
 	authController := controllers.NewAuthController(authService)
 	complaintController := controllers.NewComplaintController(complaintService)
 	drugController := controllers.NewDrugController(drugService)
+	twoFactorController := controllers.NewTwoFactorController(twoFactorService)
+	accountDeletionController := controllers.NewAccountDeletionController(accountDeletionService)
+	profileController := controllers.NewProfileController(profileService)
+	roleController := controllers.NewRoleController(roleService)
+	apiKeyController := controllers.NewApiKeyController(apiKeyService)
+	auditController := controllers.NewAuditController(auditService)
+	impersonationController := controllers.NewImpersonationController(impersonationService)
+
+	mw := middleware.NewMiddleware(cnf, keyring, sessionRepo, refreshTokenRepo, userRepo, roleRepo, apiKeyRepo, sessionCache, sessionLifetime, db, redis)
+
+	fiberApp := app.NewRouter(mw, authController, complaintController, drugController, twoFactorController, accountDeletionController, profileController, roleController, apiKeyController, auditController, impersonationController)
 
-	mw := middleware.NewMiddleware(cnf, sessionRepo, userRepo, db, redis)
+	// with prefork every child runs main too, the one off startup work and
+	// the deletion worker belong to the parent only
+	if !fiber.IsChild() {
+		if email := cnf.Env.GetString("ADMIN_BOOTSTRAP_EMAIL"); email != "" {
+			err := roleService.BootstrapAdmin(context.Background(), email)
+			if err != nil {
+				log.Println("error while bootstrap admin", err)
+			}
+		}
 
-	fiberApp := app.NewRouter(mw, authController, complaintController, drugController)
+		err := twoFactorService.SealStoredSecrets(context.Background())
+		if err != nil {
+			log.Println("error while seal stored totp secrets", err)
+		}
+
+		go accountDeletionService.RunWorker(context.Background())
+	}
 
 	if err := fiberApp.Listen(":3000"); err != nil {
 		panic(err)
@@ -4,41 +4,73 @@ import (
 	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
 	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
 	"akmmp241/dinamcom-2024/dinacom-go-rest/helpers"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
 	"akmmp241/dinamcom-2024/dinacom-go-rest/repository"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/service"
 	"context"
 	"database/sql"
 	"errors"
 	"github.com/gofiber/fiber/v2"
 	"github.com/redis/go-redis/v9"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Middleware interface {
 	Authenticate(c *fiber.Ctx) error
 	SendOtpMailRateLimiter(c *fiber.Ctx) error
+	SendVerificationMailRateLimiter(c *fiber.Ctx) error
+	SendMagicLinkMailRateLimiter(c *fiber.Ctx) error
+	AuthenticateOrApiKey(scope string) fiber.Handler
+	RequirePermission(permissions ...string) fiber.Handler
 }
 
+// ApiKeyHeader carries the API key of a partner system in place of an
+// Authorization session token.
+const ApiKeyHeader = "X-Api-Key"
+
 type MiddlewareImpl struct {
-	SessionRepo repository.SessionRepository
-	UserRepo    repository.UserRepository
-	Cnf         *config.Config
-	DB          *sql.DB
-	RedisClient *redis.Client
+	SessionRepo      repository.SessionRepository
+	RefreshTokenRepo repository.RefreshTokenRepository
+	UserRepo         repository.UserRepository
+	RoleRepo         repository.RoleRepository
+	ApiKeyRepo       repository.ApiKeyRepository
+	SessionCache     *service.SessionCache
+	SessionLifetime  *service.SessionLifetime
+	Cnf              *config.Config
+	Keyring          *config.Keyring
+	DB               *sql.DB
+	RedisClient      *redis.Client
 }
 
 func NewMiddleware(
 	cnf *config.Config,
+	keyring *config.Keyring,
 	sessionRepo repository.SessionRepository,
+	refreshTokenRepo repository.RefreshTokenRepository,
 	userRepo repository.UserRepository,
+	roleRepo repository.RoleRepository,
+	apiKeyRepo repository.ApiKeyRepository,
+	sessionCache *service.SessionCache,
+	sessionLifetime *service.SessionLifetime,
 	db *sql.DB,
 	redisClient *redis.Client,
 ) *MiddlewareImpl {
 	return &MiddlewareImpl{
-		Cnf:         cnf,
-		SessionRepo: sessionRepo,
-		UserRepo:    userRepo,
-		DB:          db,
-		RedisClient: redisClient,
+		Cnf:              cnf,
+		Keyring:          keyring,
+		SessionRepo:      sessionRepo,
+		RefreshTokenRepo: refreshTokenRepo,
+		UserRepo:         userRepo,
+		RoleRepo:         roleRepo,
+		ApiKeyRepo:       apiKeyRepo,
+		SessionCache:     sessionCache,
+		SessionLifetime:  sessionLifetime,
+		DB:               db,
+		RedisClient:      redisClient,
 	}
 }
 
@@ -48,45 +80,245 @@ func (i *MiddlewareImpl) Authenticate(c *fiber.Ctx) error {
 		return exceptions.NewBadRequestError("Missing access token")
 	}
 
-	decodedToken, err := helpers.Decrypt(accessToken, i.Cnf.Env.GetString("APP_KEY"))
+	claims, err := helpers.VerifyToken(i.Keyring, helpers.AccessTokenType, accessToken)
 	if err != nil {
 		return exceptions.NewUnauthorizedError("Unauthorized")
 	}
 
-	decodedTokenBase64 := helpers.Base64ToString(decodedToken)
+	session, user, version, err := i.loadSession(c.Context(), claims.Subject)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if session.AccessExpiresAt.Before(now) || !i.SessionLifetime.Active(session, now) {
+		return exceptions.NewUnauthorizedError("Unauthorized")
+	}
+
+	if user.EmailVerifiedAt == nil && !i.allowedForUnverified(c.Path()) {
+		return exceptions.NewForbiddenError("Email address is not verified")
+	}
+
+	if session.ImpersonatorId != nil && !i.allowedForImpersonation(session, c.Method(), c.Path()) {
+		return exceptions.NewForbiddenError("This action is not allowed while impersonating")
+	}
+
+	if now.Sub(session.LastSeenAt) > time.Minute {
+		err = i.touchSession(c.Context(), session, c.IP(), now)
+		if err != nil {
+			return err
+		}
+		i.SessionCache.Set(c.Context(), claims.Subject, version, session, user)
+	}
+
+	ctx := context.WithValue(c.UserContext(), "user", user)
+	ctx = context.WithValue(ctx, "session", session)
+	c.SetUserContext(ctx)
+
+	return c.Next()
+}
+
+// loadSession returns the session of the access token and its user with
+// roles, from the session cache when possible. On a miss the cache version is
+// read before the rows it will stamp, so a logout or change committed while
+// loading leaves the cached copy stale instead of trusted. The session has to
+// be read first to learn whose it is, so it is held under a shared lock that
+// keeps a revocation from committing, and bumping the version, until the
+// copy is loaded.
+func (i *MiddlewareImpl) loadSession(ctx context.Context, token string) (*model.Session, *model.User, int64, error) {
+	session, user, version, ok := i.SessionCache.Get(ctx, token)
+	if ok {
+		return session, user, version, nil
+	}
 
 	tx, err := i.DB.Begin()
 	if err != nil {
-		return exceptions.NewInternalServerError()
+		return nil, nil, 0, exceptions.NewInternalServerError()
 	}
 
-	session, err := i.SessionRepo.FindByToken(c.Context(), tx, decodedTokenBase64)
+	session, err = i.SessionRepo.FindByTokenForShare(ctx, tx, token)
 	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
-		return exceptions.NewUnauthorizedError("Unauthorized")
+		_ = tx.Rollback()
+		return nil, nil, 0, exceptions.NewUnauthorizedError("Unauthorized")
 	} else if err != nil {
-		return err
+		_ = tx.Rollback()
+		return nil, nil, 0, err
 	}
 
-	if session.ExpiresAt.Before(time.Now()) {
-		return exceptions.NewUnauthorizedError("Unauthorized")
+	version, versionErr := i.SessionCache.Version(ctx, session.UserId)
+
+	user, err = i.UserRepo.FindById(ctx, tx, session.UserId)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, nil, 0, err
+	}
+
+	err = i.loadRoles(ctx, tx, user)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, nil, 0, err
+	}
+
+	_ = tx.Commit()
+
+	if versionErr == nil {
+		i.SessionCache.Set(ctx, token, version, session, user)
 	}
 
-	user, err := i.UserRepo.FindById(c.Context(), tx, session.UserId)
+	return session, user, version, nil
+}
+
+// touchSession records activity on the session and slides its expiry, and
+// that of its refresh token, forward when renewal is due.
+func (i *MiddlewareImpl) touchSession(ctx context.Context, session *model.Session, ipAddress string, now time.Time) error {
+	tx, err := i.DB.Begin()
 	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	session.LastSeenAt = now
+	session.IpAddress = ipAddress
+	err = i.SessionRepo.UpdateLastSeen(ctx, tx, session.Id, session.IpAddress, session.LastSeenAt)
+	if err != nil {
+		_ = tx.Rollback()
 		return err
 	}
 
+	if i.SessionLifetime.Renew(session, now) {
+		err = i.SessionRepo.UpdateExpiresAt(ctx, tx, session.Id, session.ExpiresAt)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+
+		err = i.RefreshTokenRepo.UpdateExpiresAtBySessionId(ctx, tx, session.Id, session.ExpiresAt)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
 	_ = tx.Commit()
 
-	ctx := context.WithValue(c.UserContext(), "user", user)
-	c.SetUserContext(ctx)
+	return nil
+}
 
-	return c.Next()
+// AuthenticateOrApiKey accepts either a session token or an API key holding
+// the scope. Requests made with an API key have the key's owner as "user"
+// and the key as "api_key" in the context, but no "session". The user then
+// holds no roles and only the key's scopes as permissions, and keys stop
+// working once their owner may no longer manage API keys.
+func (i *MiddlewareImpl) AuthenticateOrApiKey(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(ApiKeyHeader)
+		if key == "" {
+			return i.Authenticate(c)
+		}
+
+		tx, err := i.DB.Begin()
+		if err != nil {
+			return exceptions.NewInternalServerError()
+		}
+
+		apiKey, err := i.ApiKeyRepo.FindByHash(c.Context(), tx, helpers.HashToken(key))
+		if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+			_ = tx.Rollback()
+			return exceptions.NewUnauthorizedError("Unauthorized")
+		} else if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+
+		if apiKey.RevokedAt != nil || apiKey.ExpiresAt.Before(time.Now()) {
+			_ = tx.Rollback()
+			return exceptions.NewUnauthorizedError("Unauthorized")
+		}
+
+		if !apiKey.HasScope(scope) {
+			_ = tx.Rollback()
+			return exceptions.NewForbiddenError("API key is missing the " + scope + " scope")
+		}
+
+		user, err := i.UserRepo.FindById(c.Context(), tx, apiKey.UserId)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+
+		err = i.loadRoles(c.Context(), tx, user)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+
+		if !user.HasPermission(model.PermissionManageApiKeys) {
+			_ = tx.Rollback()
+			return exceptions.NewUnauthorizedError("Unauthorized")
+		}
+
+		user.Roles = nil
+		user.Permissions = slices.Clone(apiKey.Scopes)
+
+		if apiKey.LastUsedAt == nil || time.Since(*apiKey.LastUsedAt) > time.Minute {
+			now := time.Now()
+			apiKey.LastUsedAt = &now
+			err = i.ApiKeyRepo.UpdateLastUsed(c.Context(), tx, apiKey.Id, now)
+			if err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+		}
+
+		_ = tx.Commit()
+
+		ctx := context.WithValue(c.UserContext(), "user", user)
+		ctx = context.WithValue(ctx, "api_key", apiKey)
+		c.SetUserContext(ctx)
+
+		return c.Next()
+	}
+}
+
+func (i *MiddlewareImpl) loadRoles(ctx context.Context, tx *sql.Tx, user *model.User) error {
+	var err error
+	user.Roles, err = i.RoleRepo.FindRoleNamesByUserId(ctx, tx, user.Id)
+	if err != nil {
+		return err
+	}
+
+	user.Permissions, err = i.RoleRepo.FindPermissionNamesByUserId(ctx, tx, user.Id)
+	return err
+}
+
+// RequirePermission lets the request through when the user holds all of the
+// permissions. It has to run after Authenticate.
+func (i *MiddlewareImpl) RequirePermission(permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user := c.UserContext().Value("user").(*model.User)
+		for _, permission := range permissions {
+			if !user.HasPermission(permission) {
+				return exceptions.NewForbiddenError("You do not have the required permission")
+			}
+		}
+
+		return c.Next()
+	}
 }
 
 func (i *MiddlewareImpl) SendOtpMailRateLimiter(c *fiber.Ctx) error {
-	ip := c.IP()
-	key := "send_otp_mail:" + ip
+	return i.rateLimit(c, "send_otp_mail:"+c.IP(), 1*time.Minute)
+}
+
+func (i *MiddlewareImpl) SendMagicLinkMailRateLimiter(c *fiber.Ctx) error {
+	return i.rateLimit(c, "send_magic_link_mail:"+c.IP(), 1*time.Minute)
+}
+
+func (i *MiddlewareImpl) SendVerificationMailRateLimiter(c *fiber.Ctx) error {
+	user := c.UserContext().Value("user").(*model.User)
+	return i.rateLimit(c, "send_verification_mail:"+strconv.Itoa(user.Id), 1*time.Minute)
+}
+
+func (i *MiddlewareImpl) rateLimit(c *fiber.Ctx, key string, window time.Duration) error {
 	err := i.RedisClient.Get(c.Context(), key).Err()
 	if err == nil {
 		return &fiber.Error{
@@ -95,10 +327,55 @@ func (i *MiddlewareImpl) SendOtpMailRateLimiter(c *fiber.Ctx) error {
 		}
 	}
 
-	err = i.RedisClient.Set(c.Context(), key, 1, 1*time.Minute).Err()
+	err = i.RedisClient.Set(c.Context(), key, 1, window).Err()
 	if err != nil {
 		return exceptions.NewInternalServerError()
 	}
 
 	return c.Next()
 }
+
+func (i *MiddlewareImpl) allowedForUnverified(path string) bool {
+	if !i.Cnf.Env.GetBool("EMAIL_VERIFICATION_REQUIRED") {
+		return true
+	}
+
+	for _, prefix := range strings.Split(i.Cnf.Env.GetString("EMAIL_VERIFICATION_ALLOWED_PATHS"), ",") {
+		prefix = strings.TrimSpace(prefix)
+		if prefix != "" && strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowedForImpersonation keeps support sessions away from
+// IMPERSONATION_BLOCKED_PATHS, reads included, and from changing data unless
+// writes were allowed. Signing out stays allowed so the session can always be
+// ended. Routing ignores case, so paths are compared in their cleaned lower
+// case form.
+func (i *MiddlewareImpl) allowedForImpersonation(session *model.Session, method string, requestPath string) bool {
+	requestPath = normalizePath(requestPath)
+	if requestPath == "/api/auth/logout" {
+		return true
+	}
+
+	for _, prefix := range strings.Split(i.Cnf.Env.GetString("IMPERSONATION_BLOCKED_PATHS"), ",") {
+		prefix = strings.TrimSpace(prefix)
+		if prefix != "" && strings.HasPrefix(requestPath, normalizePath(prefix)) {
+			return false
+		}
+	}
+
+	switch method {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return true
+	}
+
+	return !session.ImpersonationReadOnly
+}
+
+func normalizePath(p string) string {
+	return path.Clean("/" + strings.ToLower(p))
+}
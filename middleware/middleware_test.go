@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/viper"
+	"testing"
+)
+
+func TestAllowedForImpersonation(t *testing.T) {
+	env := viper.New()
+	env.Set("IMPERSONATION_BLOCKED_PATHS", "/api/auth, /api/api-keys,,/api/admin")
+	mw := &MiddlewareImpl{Cnf: &config.Config{Env: env}}
+
+	tests := []struct {
+		name     string
+		readOnly bool
+		method   string
+		path     string
+		want     bool
+	}{
+		{name: "read", readOnly: true, method: fiber.MethodGet, path: "/api/complaints", want: true},
+		{name: "head", readOnly: true, method: fiber.MethodHead, path: "/api/complaints", want: true},
+		{name: "write when read only", readOnly: true, method: fiber.MethodPost, path: "/api/complaints"},
+		{name: "delete when read only", readOnly: true, method: fiber.MethodDelete, path: "/api/complaints/1"},
+		{name: "write when allowed", method: fiber.MethodPost, path: "/api/complaints", want: true},
+		{name: "read of a blocked path", readOnly: true, method: fiber.MethodGet, path: "/api/auth/sessions"},
+		{name: "read of a blocked path with writes allowed", method: fiber.MethodGet, path: "/api/admin/audit"},
+		{name: "write to a blocked path", method: fiber.MethodPost, path: "/api/api-keys"},
+		{name: "blocked prefix with spaces", method: fiber.MethodGet, path: "/api/api-keys/1"},
+		{name: "logout", readOnly: true, method: fiber.MethodPost, path: "/api/auth/logout", want: true},
+		{name: "mixed case blocked path", method: fiber.MethodPost, path: "/API/auth/identities/google"},
+		{name: "mixed case password change", method: fiber.MethodPut, path: "/Api/auth/password"},
+		{name: "upper case blocked prefix", method: fiber.MethodPost, path: "/api/API-KEYS"},
+		{name: "doubled slashes", method: fiber.MethodPost, path: "//api//admin/roles"},
+		{name: "dot segments", method: fiber.MethodPost, path: "/api/complaints/../auth/password"},
+		{name: "mixed case logout", readOnly: true, method: fiber.MethodPost, path: "/API/Auth/Logout/", want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			impersonatorId := 1
+			session := &model.Session{ImpersonatorId: &impersonatorId, ImpersonationReadOnly: test.readOnly}
+
+			if got := mw.allowedForImpersonation(session, test.method, test.path); got != test.want {
+				t.Fatalf("allowedForImpersonation(%s %s) = %v, want %v", test.method, test.path, got, test.want)
+			}
+		})
+	}
+}
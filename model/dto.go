@@ -2,6 +2,7 @@ package model
 
 import (
 	"mime/multipart"
+	"time"
 )
 
 type GlobalResponse struct {
@@ -12,14 +13,16 @@ type GlobalResponse struct {
 
 type RegisterRequest struct {
 	Email                string `json:"email" validate:"required,email"`
-	Password             string `json:"password" validate:"required,min=8,max=255"`
+	Password             string `json:"password" validate:"required,max=255"`
 	PasswordConfirmation string `json:"password_confirmation" validate:"required,eqfield=Password"`
 }
 
 type RegisterResponse struct {
-	Id    int    `json:"id"`
-	Email string `json:"email"`
-	Token string `json:"token"`
+	Id           int    `json:"id"`
+	Email        string `json:"email"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
 }
 
 type LoginRequest struct {
@@ -28,14 +31,34 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Id    int    `json:"id"`
-	Email string `json:"email"`
-	Token string `json:"token"`
+	Id                int    `json:"id"`
+	Email             string `json:"email"`
+	Token             string `json:"token"`
+	RefreshToken      string `json:"refresh_token"`
+	ExpiresIn         int    `json:"expires_in"`
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+}
+
+type LoginTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required"`
 }
 
 type MeResponse struct {
-	Id    int    `json:"id"`
-	Email string `json:"email"`
+	Id               int      `json:"id"`
+	Email            string   `json:"email"`
+	EmailVerified    bool     `json:"email_verified"`
+	TwoFactorEnabled bool     `json:"two_factor_enabled"`
+	Roles            []string `json:"roles"`
+	// Impersonation is only present when an admin is signed in as the user
+	Impersonation *ImpersonationResponse `json:"impersonation,omitempty"`
+}
+
+type ImpersonationResponse struct {
+	ImpersonatorId int       `json:"impersonator_id"`
+	ReadOnly       bool      `json:"read_only"`
+	ExpiresAt      time.Time `json:"expires_at"`
 }
 
 type SimplifyRequest struct {
@@ -67,6 +90,18 @@ type ComplaintResponse struct {
 	ImageUrl    string                  `json:"image_url"`
 }
 
+type MagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type MagicLinkLoginRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ReportDeviceRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
 type ForgetPasswordRequest struct {
 	Email string `json:"email" validate:"required,email"`
 }
@@ -83,7 +118,8 @@ type VerifyForgetPasswordOtpResponse struct {
 
 type ResetPasswordRequest struct {
 	Email                string `json:"email" validate:"required,email"`
-	Password             string `json:"password" validate:"required,min=8,max=255"`
+	ResetPasswordToken   string `json:"reset_password_token" validate:"required"`
+	Password             string `json:"password" validate:"required,max=255"`
 	PasswordConfirmation string `json:"password_confirmation" validate:"required,eqfield=Password"`
 }
 
@@ -104,18 +140,10 @@ type UpdateComplaintRequest struct {
 	SuggestedTitle string `json:"suggested_title" validate:"required"`
 }
 
-type GoogleUserInfo struct {
-	Id            string `json:"id"`
-	Email         string `json:"email"`
-	Name          string `json:"name"`
-	FamilyName    string `json:"family_name"`
-	GivenName     string `json:"given_name"`
-	Picture       string `json:"picture"`
-	VerifiedEmail bool   `json:"verified_email"`
-}
-
-type GoogleCallbackRequest struct {
-	Token string `json:"token" validate:"required"`
+type OauthCallbackRequest struct {
+	Code       string `query:"code" validate:"required"`
+	State      string `query:"state" validate:"required"`
+	BoundState string `query:"-"`
 }
 
 type GetDrugDetailResponse struct {
@@ -126,3 +154,187 @@ type GetDrugDetailResponse struct {
 	Price       float32 `json:"price"`
 	ImageUrl    string  `json:"image_url"`
 }
+
+type SessionResponse struct {
+	Id          int       `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	IpAddress   string    `json:"ip_address"`
+	UserAgent   string    `json:"user_agent"`
+	DeviceLabel string    `json:"device_label"`
+	Current     bool      `json:"current"`
+	// Impersonated marks sessions opened by support staff
+	Impersonated bool `json:"impersonated"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type RefreshTokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+type TwoFactorEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthUri string `json:"otpauth_uri"`
+}
+
+type TwoFactorEnrollRequest struct {
+	Password string `json:"password"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type TwoFactorDisableRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code" validate:"required"`
+}
+
+type TwoFactorConfirmResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword      string `json:"current_password"`
+	Password             string `json:"password" validate:"required,max=255"`
+	PasswordConfirmation string `json:"password_confirmation" validate:"required,eqfield=Password"`
+	RevokeOtherSessions  bool   `json:"revoke_other_sessions"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type AccountDeletionReceipt struct {
+	Id                string     `json:"id"`
+	Status            string     `json:"status"`
+	ComplaintsDeleted int        `json:"complaints_deleted"`
+	ImagesDeleted     int        `json:"images_deleted"`
+	AiFilesDeleted    int        `json:"ai_files_deleted"`
+	RequestedAt       time.Time  `json:"requested_at"`
+	CompletedAt       *time.Time `json:"completed_at"`
+}
+
+type UpdateProfileRequest struct {
+	DisplayName        string   `json:"display_name" validate:"max=255"`
+	AvatarUrl          string   `json:"avatar_url" validate:"omitempty,url,max=1024"`
+	DateOfBirth        string   `json:"date_of_birth" validate:"omitempty,datetime=2006-01-02"`
+	Sex                string   `json:"sex" validate:"omitempty,oneof=male female other"`
+	Allergies          []string `json:"allergies" validate:"max=50,dive,required,max=255"`
+	ChronicConditions  []string `json:"chronic_conditions" validate:"max=50,dive,required,max=255"`
+	CurrentMedications []string `json:"current_medications" validate:"max=50,dive,required,max=255"`
+}
+
+type ProfileResponse struct {
+	DisplayName        string   `json:"display_name"`
+	AvatarUrl          string   `json:"avatar_url"`
+	DateOfBirth        string   `json:"date_of_birth"`
+	Sex                string   `json:"sex"`
+	Allergies          []string `json:"allergies"`
+	ChronicConditions  []string `json:"chronic_conditions"`
+	CurrentMedications []string `json:"current_medications"`
+}
+
+type IdentityResponse struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type IdentitiesResponse struct {
+	HasPassword bool               `json:"has_password"`
+	Identities  []IdentityResponse `json:"identities"`
+}
+
+type LinkIdentityResponse struct {
+	AuthorizationUrl string `json:"authorization_url"`
+}
+
+type AssignRoleRequest struct {
+	Role string `json:"role" validate:"required,max=50"`
+}
+
+type ImpersonateRequest struct {
+	Reason      string `json:"reason" validate:"required,max=200"`
+	AllowWrites bool   `json:"allow_writes"`
+}
+
+type ImpersonateResponse struct {
+	UserId    int       `json:"user_id"`
+	Email     string    `json:"email"`
+	Token     string    `json:"token"`
+	ExpiresIn int       `json:"expires_in"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ReadOnly  bool      `json:"read_only"`
+}
+
+type RoleResponse struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type UserRolesResponse struct {
+	UserId      int      `json:"user_id"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+type CreateApiKeyRequest struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,oneof=complaints:read complaints:write"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"omitempty,min=1"`
+}
+
+type ApiKeyResponse struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreateApiKeyResponse is the only time the plain key is ever returned.
+type CreateApiKeyResponse struct {
+	Key    string         `json:"key"`
+	ApiKey ApiKeyResponse `json:"api_key"`
+}
+
+type AuditEventQuery struct {
+	UserId    int    `json:"user_id" query:"user_id"`
+	Email     string `json:"email" query:"email"`
+	Event     string `json:"event" query:"event"`
+	Outcome   string `json:"outcome" query:"outcome" validate:"omitempty,oneof=success failure"`
+	IpAddress string `json:"ip_address" query:"ip_address"`
+	From      string `json:"from" query:"from" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	To        string `json:"to" query:"to" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	Before    int64  `json:"before" query:"before"`
+	Limit     int    `json:"limit" query:"limit"`
+}
+
+type AuditEventResponse struct {
+	Id        int64     `json:"id"`
+	UserId    *int      `json:"user_id"`
+	Email     string    `json:"email"`
+	Event     string    `json:"event"`
+	Outcome   string    `json:"outcome"`
+	IpAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditEventsResponse pages backwards in time; pass NextBefore as before to
+// get the following page. It is zero on the last page.
+type AuditEventsResponse struct {
+	Events     []AuditEventResponse `json:"events"`
+	NextBefore int64                `json:"next_before"`
+}
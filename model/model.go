@@ -1,29 +1,141 @@
 package model
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 type User struct {
-	Id       int
-	Email    string
-	Password string
-	Provider string
+	Id              int
+	Email           string
+	Password        string
+	Provider        string
+	EmailVerifiedAt *time.Time
+	TotpSecret      string
+	TotpEnabledAt   *time.Time
+	// PasswordResetRequiredAt blocks password sign in until the password is reset
+	PasswordResetRequiredAt *time.Time
+	// Roles and Permissions are loaded by the Authenticate middleware
+	Roles       []string
+	Permissions []string
+}
+
+const (
+	RoleUser      = "user"
+	RoleClinician = "clinician"
+	RoleAdmin     = "admin"
+
+	PermissionReadAnyComplaint = "complaints:read_any"
+	PermissionReadUsers        = "users:read"
+	PermissionManageRoles      = "roles:manage"
+	PermissionManageApiKeys    = "api_keys:manage"
+	PermissionReadAudit        = "audit:read"
+	PermissionImpersonateUsers = "users:impersonate"
+)
+
+// HasRole reports whether the user holds the role. Every user implicitly
+// holds RoleUser.
+func (u *User) HasRole(role string) bool {
+	return role == RoleUser || slices.Contains(u.Roles, role)
+}
+
+func (u *User) HasPermission(permission string) bool {
+	return slices.Contains(u.Permissions, permission)
+}
+
+type Role struct {
+	Id          int
+	Name        string
+	Description string
+	Permissions []string
+}
+
+const (
+	ScopeComplaintsRead  = "complaints:read"
+	ScopeComplaintsWrite = "complaints:write"
+)
+
+// KnownDevice is an IP address and user agent pair the user has signed in
+// from before. Fingerprint is the hash of both.
+type KnownDevice struct {
+	Id          int
+	UserId      int
+	Fingerprint string
+	IpAddress   string
+	UserAgent   string
+	DeviceLabel string
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
+}
+
+// ApiKey lets a partner system act as its owner without a session. Only the
+// hash of the key is stored; the prefix identifies it in listings.
+type ApiKey struct {
+	Id         int
+	UserId     int
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scopes     []string
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+func (k *ApiKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
 }
 
 type Session struct {
+	Id              int
+	UserId          int
+	Token           string
+	ExpiresAt       time.Time
+	AccessExpiresAt time.Time
+	RevokedAt       *time.Time
+	CreatedAt       time.Time
+	LastSeenAt      time.Time
+	IpAddress       string
+	UserAgent       string
+	DeviceLabel     string
+	// ImpersonatorId is set on sessions an admin opened as the user for
+	// support; ImpersonationReadOnly then blocks write requests
+	ImpersonatorId        *int
+	ImpersonationReadOnly bool
+}
+
+type RefreshToken struct {
 	Id        int
-	UserId    int
+	SessionId int
 	Token     string
 	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type RecoveryCode struct {
+	Id        int
+	UserId    int
+	Code      string
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type ClientInfo struct {
+	IpAddress string
+	UserAgent string
 }
 
 type Complaint struct {
-	Id            string
-	UserId        int
-	Title         string
-	ComplaintsMsg string
-	Response      string
-	ImageUrl      string
-	CreatedAt     time.Time
+	Id             string
+	UserId         int
+	Title          string
+	ComplaintsMsg  string
+	Response       string
+	ImageUrl       string
+	GeminiFileName string
+	CreatedAt      time.Time
 }
 
 type Drug struct {
@@ -34,3 +146,95 @@ type Drug struct {
 	Price       float32
 	ImageUrl    string
 }
+
+type UserIdentity struct {
+	Id        int
+	UserId    int
+	Provider  string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+}
+
+type UserProfile struct {
+	UserId             int
+	DisplayName        string
+	AvatarUrl          string
+	DateOfBirth        *time.Time
+	Sex                string
+	Allergies          []string
+	ChronicConditions  []string
+	CurrentMedications []string
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+}
+
+type AccountDeletion struct {
+	Id                string
+	UserId            int
+	Status            string
+	Attempts          int
+	LastError         string
+	ComplaintsDeleted int
+	ImagesDeleted     int
+	AiFilesDeleted    int
+	RequestedAt       time.Time
+	StartedAt         *time.Time
+	CompletedAt       *time.Time
+}
+
+const (
+	AuditRegister          = "register"
+	AuditLogin             = "login"
+	AuditLoginTwoFactor    = "login_2fa"
+	AuditMagicLinkLogin    = "magic_link_login"
+	AuditOauthLogin        = "oauth_login"
+	AuditOtpRequested      = "password_reset_otp_requested"
+	AuditOtpVerified       = "password_reset_otp_verified"
+	AuditPasswordReset     = "password_reset"
+	AuditPasswordChanged   = "password_changed"
+	AuditLogout            = "logout"
+	AuditLogoutAll         = "logout_all"
+	AuditSessionRevoked    = "session_revoked"
+	AuditRefreshTokenReuse = "refresh_token_reuse"
+	AuditNewDeviceLogin    = "new_device_login"
+	AuditDeviceReported    = "device_reported"
+	AuditImpersonation     = "impersonation_started"
+	AuditImpersonated      = "impersonated"
+	AuditRoleAssigned      = "role_assigned"
+	AuditRoleRevoked       = "role_revoked"
+	AuditApiKeyCreated     = "api_key_created"
+	AuditApiKeyRevoked     = "api_key_revoked"
+	AuditTwoFactorEnabled  = "2fa_enabled"
+	AuditTwoFactorDisabled = "2fa_disabled"
+
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEvent is an append only record of a security relevant action. UserId
+// is nil when the attempt could not be tied to an account, e.g. a failed login
+// for an unknown email.
+type AuditEvent struct {
+	Id        int64
+	UserId    *int
+	Email     string
+	Event     string
+	Outcome   string
+	IpAddress string
+	UserAgent string
+	Detail    string
+	CreatedAt time.Time
+}
+
+type AuditEventFilter struct {
+	UserId    *int
+	Email     string
+	Event     string
+	Outcome   string
+	IpAddress string
+	From      *time.Time
+	To        *time.Time
+	BeforeId  int64
+	Limit     int
+}
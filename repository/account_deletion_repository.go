@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+const (
+	AccountDeletionPending   = "pending"
+	AccountDeletionRunning   = "running"
+	AccountDeletionCompleted = "completed"
+	AccountDeletionFailed    = "failed"
+)
+
+type AccountDeletionRepository interface {
+	Save(ctx context.Context, tx *sql.Tx, deletion *model.AccountDeletion) (*model.AccountDeletion, error)
+	FindById(ctx context.Context, tx *sql.Tx, id string) (*model.AccountDeletion, error)
+	FindRunnable(ctx context.Context, tx *sql.Tx, maxAttempts int, staleBefore time.Time) ([]model.AccountDeletion, error)
+	Claim(ctx context.Context, tx *sql.Tx, deletion *model.AccountDeletion, staleBefore time.Time) (bool, error)
+	Update(ctx context.Context, tx *sql.Tx, deletion *model.AccountDeletion) error
+	PurgeUser(ctx context.Context, tx *sql.Tx, userId int) error
+}
+
+type AccountDeletionRepositoryImpl struct {
+}
+
+func NewAccountDeletionRepository() *AccountDeletionRepositoryImpl {
+	return &AccountDeletionRepositoryImpl{}
+}
+
+const accountDeletionColumns = `id, user_id, status, attempts, last_error, complaints_deleted, images_deleted, ai_files_deleted, requested_at, started_at, completed_at`
+
+func scanAccountDeletion(rows *sql.Rows) (*model.AccountDeletion, error) {
+	var deletion model.AccountDeletion
+	err := rows.Scan(&deletion.Id, &deletion.UserId, &deletion.Status, &deletion.Attempts, &deletion.LastError, &deletion.ComplaintsDeleted,
+		&deletion.ImagesDeleted, &deletion.AiFilesDeleted, &deletion.RequestedAt, &deletion.StartedAt, &deletion.CompletedAt)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	return &deletion, nil
+}
+
+func (a AccountDeletionRepositoryImpl) Save(ctx context.Context, tx *sql.Tx, deletion *model.AccountDeletion) (*model.AccountDeletion, error) {
+	query := `INSERT INTO account_deletions (id, user_id, status, requested_at) VALUES (?, ?, ?, ?)`
+	_, err := tx.ExecContext(ctx, query, deletion.Id, deletion.UserId, deletion.Status, deletion.RequestedAt)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	return deletion, nil
+}
+
+func (a AccountDeletionRepositoryImpl) FindById(ctx context.Context, tx *sql.Tx, id string) (*model.AccountDeletion, error) {
+	query := `SELECT ` + accountDeletionColumns + ` FROM account_deletions WHERE id = ?`
+	rows, err := tx.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, exceptions.NewNotFoundError()
+	}
+
+	return scanAccountDeletion(rows)
+}
+
+// FindRunnable returns jobs that are waiting, failed but still have attempts
+// left, or were left running by a worker that died before finishing.
+func (a AccountDeletionRepositoryImpl) FindRunnable(ctx context.Context, tx *sql.Tx, maxAttempts int, staleBefore time.Time) ([]model.AccountDeletion, error) {
+	query := `SELECT ` + accountDeletionColumns + ` FROM account_deletions
+		WHERE attempts < ? AND (status IN ('pending', 'failed') OR (status = 'running' AND started_at < ?))
+		ORDER BY requested_at`
+	rows, err := tx.QueryContext(ctx, query, maxAttempts, staleBefore)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+	defer rows.Close()
+
+	var deletions []model.AccountDeletion
+	for rows.Next() {
+		deletion, err := scanAccountDeletion(rows)
+		if err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, *deletion)
+	}
+
+	return deletions, nil
+}
+
+// Claim marks the job as running for this worker. It reports false when
+// another worker claimed it first.
+func (a AccountDeletionRepositoryImpl) Claim(ctx context.Context, tx *sql.Tx, deletion *model.AccountDeletion, staleBefore time.Time) (bool, error) {
+	now := time.Now()
+	query := `UPDATE account_deletions SET status = 'running', attempts = attempts + 1, started_at = ?
+		WHERE id = ? AND (status IN ('pending', 'failed') OR (status = 'running' AND started_at < ?))`
+	result, err := tx.ExecContext(ctx, query, now, deletion.Id, staleBefore)
+	if err != nil {
+		log.Println(err.Error())
+		return false, exceptions.NewInternalServerError()
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, exceptions.NewInternalServerError()
+	}
+
+	if affected == 1 {
+		deletion.Status = AccountDeletionRunning
+		deletion.Attempts++
+		deletion.StartedAt = &now
+	}
+
+	return affected == 1, nil
+}
+
+func (a AccountDeletionRepositoryImpl) Update(ctx context.Context, tx *sql.Tx, deletion *model.AccountDeletion) error {
+	query := `UPDATE account_deletions SET status = ?, last_error = ?, complaints_deleted = ?, images_deleted = ?, ai_files_deleted = ?, completed_at = ? WHERE id = ?`
+	_, err := tx.ExecContext(ctx, query, deletion.Status, deletion.LastError, deletion.ComplaintsDeleted, deletion.ImagesDeleted,
+		deletion.AiFilesDeleted, deletion.CompletedAt, deletion.Id)
+	if err != nil {
+		log.Println(err.Error())
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
+
+// PurgeUser removes every row that belongs to the user, children first so
+// the foreign keys hold. Audit events are kept for the security record, only
+// stripped of what ties them to the person.
+func (a AccountDeletionRepositoryImpl) PurgeUser(ctx context.Context, tx *sql.Tx, userId int) error {
+	queries := []string{
+		`DELETE refresh_tokens FROM refresh_tokens JOIN sessions ON sessions.id = refresh_tokens.session_id WHERE sessions.user_id = ?`,
+		`DELETE FROM sessions WHERE user_id = ?`,
+		`DELETE FROM sessions WHERE impersonator_id = ?`,
+		`DELETE FROM recovery_codes WHERE user_id = ?`,
+		`DELETE FROM complaints WHERE user_id = ?`,
+		`DELETE FROM user_profiles WHERE user_id = ?`,
+		`DELETE FROM user_identities WHERE user_id = ?`,
+		`DELETE FROM user_roles WHERE user_id = ?`,
+		`DELETE FROM api_keys WHERE user_id = ?`,
+		`UPDATE audit_events SET user_id = NULL, email = '' WHERE user_id = ?`,
+		`DELETE FROM known_devices WHERE user_id = ?`,
+		`DELETE FROM users WHERE id = ?`,
+	}
+
+	for _, query := range queries {
+		_, err := tx.ExecContext(ctx, query, userId)
+		if err != nil {
+			log.Println(err.Error())
+			return exceptions.NewInternalServerError()
+		}
+	}
+
+	return nil
+}
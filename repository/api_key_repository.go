@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/helpers"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+type ApiKeyRepository interface {
+	Save(ctx context.Context, tx *sql.Tx, apiKey *model.ApiKey) (*model.ApiKey, error)
+	FindByHash(ctx context.Context, tx *sql.Tx, keyHash string) (*model.ApiKey, error)
+	FindAllActiveByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]model.ApiKey, error)
+	UpdateLastUsed(ctx context.Context, tx *sql.Tx, id int, lastUsedAt time.Time) error
+	Revoke(ctx context.Context, tx *sql.Tx, userId int, id int) (bool, error)
+	RevokeAllByUserId(ctx context.Context, tx *sql.Tx, userId int) error
+}
+
+type ApiKeyRepositoryImpl struct {
+}
+
+func NewApiKeyRepository() *ApiKeyRepositoryImpl {
+	return &ApiKeyRepositoryImpl{}
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at`
+
+func scanApiKey(rows *sql.Rows) (*model.ApiKey, error) {
+	var apiKey model.ApiKey
+	var scopes []byte
+	err := rows.Scan(&apiKey.Id, &apiKey.UserId, &apiKey.Name, &apiKey.Prefix, &apiKey.KeyHash, &scopes, &apiKey.ExpiresAt,
+		&apiKey.LastUsedAt, &apiKey.RevokedAt, &apiKey.CreatedAt)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	if json.Unmarshal(scopes, &apiKey.Scopes) != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	return &apiKey, nil
+}
+
+func (a ApiKeyRepositoryImpl) Save(ctx context.Context, tx *sql.Tx, apiKey *model.ApiKey) (*model.ApiKey, error) {
+	scopes, _ := json.Marshal(helpers.NonNilStrings(apiKey.Scopes))
+
+	query := `INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.ExecContext(ctx, query, apiKey.UserId, apiKey.Name, apiKey.Prefix, apiKey.KeyHash, scopes, apiKey.ExpiresAt, apiKey.CreatedAt)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	apiKey.Id = int(id)
+	return apiKey, nil
+}
+
+func (a ApiKeyRepositoryImpl) FindByHash(ctx context.Context, tx *sql.Tx, keyHash string) (*model.ApiKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = ?`
+	rows, err := tx.QueryContext(ctx, query, keyHash)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, exceptions.NewNotFoundError()
+	}
+
+	return scanApiKey(rows)
+}
+
+func (a ApiKeyRepositoryImpl) FindAllActiveByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]model.ApiKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ? ORDER BY created_at DESC`
+	rows, err := tx.QueryContext(ctx, query, userId, time.Now())
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+	defer rows.Close()
+
+	var apiKeys []model.ApiKey
+	for rows.Next() {
+		apiKey, err := scanApiKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		apiKeys = append(apiKeys, *apiKey)
+	}
+
+	return apiKeys, nil
+}
+
+func (a ApiKeyRepositoryImpl) UpdateLastUsed(ctx context.Context, tx *sql.Tx, id int, lastUsedAt time.Time) error {
+	query := `UPDATE api_keys SET last_used_at = ? WHERE id = ?`
+	_, err := tx.ExecContext(ctx, query, lastUsedAt, id)
+	if err != nil {
+		log.Println(err.Error())
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
+
+// Revoke reports false when the user has no active key with the id.
+func (a ApiKeyRepositoryImpl) Revoke(ctx context.Context, tx *sql.Tx, userId int, id int) (bool, error) {
+	query := `UPDATE api_keys SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, time.Now(), id, userId)
+	if err != nil {
+		log.Println(err.Error())
+		return false, exceptions.NewInternalServerError()
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, exceptions.NewInternalServerError()
+	}
+
+	return affected > 0, nil
+}
+
+func (a ApiKeyRepositoryImpl) RevokeAllByUserId(ctx context.Context, tx *sql.Tx, userId int) error {
+	query := `UPDATE api_keys SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`
+	_, err := tx.ExecContext(ctx, query, time.Now(), userId)
+	if err != nil {
+		log.Println(err.Error())
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+)
+
+type AuditEventRepository interface {
+	Save(ctx context.Context, tx *sql.Tx, event *model.AuditEvent) (*model.AuditEvent, error)
+	FindAll(ctx context.Context, tx *sql.Tx, filter model.AuditEventFilter) ([]model.AuditEvent, error)
+}
+
+type AuditEventRepositoryImpl struct {
+}
+
+func NewAuditEventRepository() *AuditEventRepositoryImpl {
+	return &AuditEventRepositoryImpl{}
+}
+
+const auditEventColumns = `id, user_id, email, event, outcome, ip_address, user_agent, detail, created_at`
+
+func (a AuditEventRepositoryImpl) Save(ctx context.Context, tx *sql.Tx, event *model.AuditEvent) (*model.AuditEvent, error) {
+	query := `INSERT INTO audit_events (user_id, email, event, outcome, ip_address, user_agent, detail, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.ExecContext(ctx, query, event.UserId, event.Email, event.Event, event.Outcome, event.IpAddress,
+		truncate(event.UserAgent, 512), truncate(event.Detail, 255), event.CreatedAt)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	event.Id = id
+	return event, nil
+}
+
+// FindAll returns the newest events matching every set field of the filter.
+// Pages are walked backwards with BeforeId, the id of the last event seen.
+func (a AuditEventRepositoryImpl) FindAll(ctx context.Context, tx *sql.Tx, filter model.AuditEventFilter) ([]model.AuditEvent, error) {
+	var conditions []string
+	var args []any
+
+	if filter.UserId != nil {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, *filter.UserId)
+	}
+	if filter.Email != "" {
+		conditions = append(conditions, "email = ?")
+		args = append(args, filter.Email)
+	}
+	if filter.Event != "" {
+		conditions = append(conditions, "event = ?")
+		args = append(args, filter.Event)
+	}
+	if filter.Outcome != "" {
+		conditions = append(conditions, "outcome = ?")
+		args = append(args, filter.Outcome)
+	}
+	if filter.IpAddress != "" {
+		conditions = append(conditions, "ip_address = ?")
+		args = append(args, filter.IpAddress)
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, *filter.To)
+	}
+	if filter.BeforeId > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.BeforeId)
+	}
+
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, filter.Limit)
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, exceptions.NewInternalServerError()
+	}
+	defer rows.Close()
+
+	var events []model.AuditEvent
+	for rows.Next() {
+		var event model.AuditEvent
+		err := rows.Scan(&event.Id, &event.UserId, &event.Email, &event.Event, &event.Outcome, &event.IpAddress,
+			&event.UserAgent, &event.Detail, &event.CreatedAt)
+		if err != nil {
+			return nil, exceptions.NewInternalServerError()
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func truncate(value string, length int) string {
+	if len(value) <= length {
+		return value
+	}
+	return strings.ToValidUTF8(value[:length], "")
+}
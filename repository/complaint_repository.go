@@ -22,8 +22,8 @@ func NewComplaintRepository() *ComplaintRepositoryImpl {
 }
 
 func (c ComplaintRepositoryImpl) Save(ctx context.Context, tx *sql.Tx, complaints *model.Complaint) (*model.Complaint, error) {
-	query := `INSERT INTO complaints (id, user_id, title, complaints, response, image_url, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
-	_, err := tx.ExecContext(ctx, query, &complaints.Id, &complaints.UserId, &complaints.Title, &complaints.ComplaintsMsg, &complaints.Response, &complaints.ImageUrl, &complaints.CreatedAt)
+	query := `INSERT INTO complaints (id, user_id, title, complaints, response, image_url, gemini_file_name, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := tx.ExecContext(ctx, query, &complaints.Id, &complaints.UserId, &complaints.Title, &complaints.ComplaintsMsg, &complaints.Response, &complaints.ImageUrl, &complaints.GeminiFileName, &complaints.CreatedAt)
 	if err != nil {
 		return nil, exceptions.NewInternalServerError()
 	}
@@ -32,7 +32,7 @@ func (c ComplaintRepositoryImpl) Save(ctx context.Context, tx *sql.Tx, complaint
 }
 
 func (c ComplaintRepositoryImpl) FindAll(ctx context.Context, tx *sql.Tx, userId int) ([]model.Complaint, error) {
-	query := `SELECT id, user_id, title, complaints, response, image_url, created_at FROM complaints WHERE user_id = ?`
+	query := `SELECT id, user_id, title, complaints, response, image_url, gemini_file_name, created_at FROM complaints WHERE user_id = ?`
 	rows, err := tx.QueryContext(ctx, query, &userId)
 	if err != nil {
 		return nil, err
@@ -42,7 +42,7 @@ func (c ComplaintRepositoryImpl) FindAll(ctx context.Context, tx *sql.Tx, userId
 	var complaints []model.Complaint
 	for rows.Next() {
 		var complaint model.Complaint
-		err := rows.Scan(&complaint.Id, &complaint.UserId, &complaint.Title, &complaint.ComplaintsMsg, &complaint.Response, &complaint.ImageUrl, &complaint.CreatedAt)
+		err := rows.Scan(&complaint.Id, &complaint.UserId, &complaint.Title, &complaint.ComplaintsMsg, &complaint.Response, &complaint.ImageUrl, &complaint.GeminiFileName, &complaint.CreatedAt)
 		if err != nil {
 			return nil, exceptions.NewInternalServerError()
 		}
@@ -53,7 +53,7 @@ func (c ComplaintRepositoryImpl) FindAll(ctx context.Context, tx *sql.Tx, userId
 }
 
 func (c ComplaintRepositoryImpl) FindById(ctx context.Context, tx *sql.Tx, id string) (*model.Complaint, error) {
-	query := `SELECT id, user_id, title, complaints, response, image_url, created_at FROM complaints WHERE id = ?`
+	query := `SELECT id, user_id, title, complaints, response, image_url, gemini_file_name, created_at FROM complaints WHERE id = ?`
 	rows, err := tx.QueryContext(ctx, query, &id)
 	if err != nil {
 		return nil, err
@@ -65,7 +65,7 @@ func (c ComplaintRepositoryImpl) FindById(ctx context.Context, tx *sql.Tx, id st
 		return nil, exceptions.NewNotFoundError()
 	}
 
-	err = rows.Scan(&complaint.Id, &complaint.UserId, &complaint.Title, &complaint.ComplaintsMsg, &complaint.Response, &complaint.ImageUrl, &complaint.CreatedAt)
+	err = rows.Scan(&complaint.Id, &complaint.UserId, &complaint.Title, &complaint.ComplaintsMsg, &complaint.Response, &complaint.ImageUrl, &complaint.GeminiFileName, &complaint.CreatedAt)
 	if err != nil {
 		return nil, exceptions.NewInternalServerError()
 	}
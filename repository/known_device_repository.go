@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"context"
+	"database/sql"
+	"log"
+)
+
+type KnownDeviceRepository interface {
+	Remember(ctx context.Context, tx *sql.Tx, device *model.KnownDevice) (bool, error)
+	CountByUserId(ctx context.Context, tx *sql.Tx, userId int) (int, error)
+	Forget(ctx context.Context, tx *sql.Tx, userId int, fingerprint string) error
+}
+
+type KnownDeviceRepositoryImpl struct {
+}
+
+func NewKnownDeviceRepository() *KnownDeviceRepositoryImpl {
+	return &KnownDeviceRepositoryImpl{}
+}
+
+// Remember stores the device or bumps its last seen time. It reports true
+// only when the device was not known for the user yet.
+func (k KnownDeviceRepositoryImpl) Remember(ctx context.Context, tx *sql.Tx, device *model.KnownDevice) (bool, error) {
+	query := `INSERT INTO known_devices (user_id, fingerprint, ip_address, user_agent, device_label, first_seen_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE last_seen_at = VALUES(last_seen_at)`
+	result, err := tx.ExecContext(ctx, query, device.UserId, device.Fingerprint, truncate(device.IpAddress, 45), truncate(device.UserAgent, 512),
+		device.DeviceLabel, device.FirstSeenAt, device.LastSeenAt)
+	if err != nil {
+		log.Println(err.Error())
+		return false, exceptions.NewInternalServerError()
+	}
+
+	// MySQL counts an inserted row as 1 and an updated one as 2
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, exceptions.NewInternalServerError()
+	}
+
+	return affected == 1, nil
+}
+
+func (k KnownDeviceRepositoryImpl) CountByUserId(ctx context.Context, tx *sql.Tx, userId int) (int, error) {
+	query := `SELECT COUNT(*) FROM known_devices WHERE user_id = ?`
+	var count int
+	err := tx.QueryRowContext(ctx, query, userId).Scan(&count)
+	if err != nil {
+		return 0, exceptions.NewInternalServerError()
+	}
+
+	return count, nil
+}
+
+func (k KnownDeviceRepositoryImpl) Forget(ctx context.Context, tx *sql.Tx, userId int, fingerprint string) error {
+	query := `DELETE FROM known_devices WHERE user_id = ? AND fingerprint = ?`
+	_, err := tx.ExecContext(ctx, query, userId, fingerprint)
+	if err != nil {
+		log.Println(err.Error())
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+type RecoveryCodeRepository interface {
+	Save(ctx context.Context, tx *sql.Tx, recoveryCode *model.RecoveryCode) (*model.RecoveryCode, error)
+	FindUnusedByUserIdAndCode(ctx context.Context, tx *sql.Tx, userId int, code string) (*model.RecoveryCode, error)
+	MarkUsed(ctx context.Context, tx *sql.Tx, id int) (bool, error)
+	DeleteAllByUserId(ctx context.Context, tx *sql.Tx, userId int) error
+}
+
+type RecoveryCodeRepositoryImpl struct {
+}
+
+func NewRecoveryCodeRepository() *RecoveryCodeRepositoryImpl {
+	return &RecoveryCodeRepositoryImpl{}
+}
+
+func (r RecoveryCodeRepositoryImpl) Save(ctx context.Context, tx *sql.Tx, recoveryCode *model.RecoveryCode) (*model.RecoveryCode, error) {
+	query := `INSERT INTO recovery_codes (id, user_id, code, created_at) VALUES (NULL, ?, ?, ?)`
+	result, err := tx.ExecContext(ctx, query, recoveryCode.UserId, recoveryCode.Code, recoveryCode.CreatedAt)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	recoveryCode.Id = int(id)
+	return recoveryCode, nil
+}
+
+func (r RecoveryCodeRepositoryImpl) FindUnusedByUserIdAndCode(ctx context.Context, tx *sql.Tx, userId int, code string) (*model.RecoveryCode, error) {
+	query := `SELECT id, user_id, code, used_at, created_at FROM recovery_codes WHERE user_id = ? AND code = ? AND used_at IS NULL`
+	rows, err := tx.QueryContext(ctx, query, userId, code)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+	defer rows.Close()
+
+	var recoveryCode model.RecoveryCode
+	if !rows.Next() {
+		return nil, exceptions.NewNotFoundError()
+	}
+
+	err = rows.Scan(&recoveryCode.Id, &recoveryCode.UserId, &recoveryCode.Code, &recoveryCode.UsedAt, &recoveryCode.CreatedAt)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	return &recoveryCode, nil
+}
+
+func (r RecoveryCodeRepositoryImpl) MarkUsed(ctx context.Context, tx *sql.Tx, id int) (bool, error) {
+	query := `UPDATE recovery_codes SET used_at = ? WHERE id = ? AND used_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		log.Println(err.Error())
+		return false, exceptions.NewInternalServerError()
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, exceptions.NewInternalServerError()
+	}
+
+	return affected == 1, nil
+}
+
+func (r RecoveryCodeRepositoryImpl) DeleteAllByUserId(ctx context.Context, tx *sql.Tx, userId int) error {
+	query := `DELETE FROM recovery_codes WHERE user_id = ?`
+	_, err := tx.ExecContext(ctx, query, userId)
+	if err != nil {
+		log.Println(err.Error())
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
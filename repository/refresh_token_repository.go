@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+type RefreshTokenRepository interface {
+	Save(ctx context.Context, tx *sql.Tx, refreshToken *model.RefreshToken) (*model.RefreshToken, error)
+	FindByToken(ctx context.Context, tx *sql.Tx, token string) (*model.RefreshToken, error)
+	MarkUsed(ctx context.Context, tx *sql.Tx, id int) (bool, error)
+	UpdateExpiresAtBySessionId(ctx context.Context, tx *sql.Tx, sessionId int, expiresAt time.Time) error
+}
+
+type RefreshTokenRepositoryImpl struct {
+}
+
+func NewRefreshTokenRepository() *RefreshTokenRepositoryImpl {
+	return &RefreshTokenRepositoryImpl{}
+}
+
+func (r RefreshTokenRepositoryImpl) Save(ctx context.Context, tx *sql.Tx, refreshToken *model.RefreshToken) (*model.RefreshToken, error) {
+	query := `INSERT INTO refresh_tokens (id, session_id, token, expires_at, created_at) VALUES (NULL, ?, ?, ?, ?)`
+	result, err := tx.ExecContext(ctx, query, refreshToken.SessionId, refreshToken.Token, refreshToken.ExpiresAt, refreshToken.CreatedAt)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	refreshToken.Id = int(id)
+	return refreshToken, nil
+}
+
+func (r RefreshTokenRepositoryImpl) FindByToken(ctx context.Context, tx *sql.Tx, token string) (*model.RefreshToken, error) {
+	query := `SELECT id, session_id, token, expires_at, used_at, created_at FROM refresh_tokens WHERE token = ?`
+	rows, err := tx.QueryContext(ctx, query, token)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+	defer rows.Close()
+
+	var refreshToken model.RefreshToken
+	if !rows.Next() {
+		return nil, exceptions.NewNotFoundError()
+	}
+
+	err = rows.Scan(&refreshToken.Id, &refreshToken.SessionId, &refreshToken.Token, &refreshToken.ExpiresAt, &refreshToken.UsedAt, &refreshToken.CreatedAt)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	return &refreshToken, nil
+}
+
+// MarkUsed flags the refresh token as consumed. It reports false when the
+// token had already been used, which callers must treat as a replay.
+func (r RefreshTokenRepositoryImpl) MarkUsed(ctx context.Context, tx *sql.Tx, id int) (bool, error) {
+	query := `UPDATE refresh_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		log.Println(err.Error())
+		return false, exceptions.NewInternalServerError()
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, exceptions.NewInternalServerError()
+	}
+
+	return affected == 1, nil
+}
+
+// UpdateExpiresAtBySessionId moves the expiry of the session's unused refresh
+// tokens along with a renewed session.
+func (r RefreshTokenRepositoryImpl) UpdateExpiresAtBySessionId(ctx context.Context, tx *sql.Tx, sessionId int, expiresAt time.Time) error {
+	query := `UPDATE refresh_tokens SET expires_at = ? WHERE session_id = ? AND used_at IS NULL`
+	_, err := tx.ExecContext(ctx, query, expiresAt, sessionId)
+	if err != nil {
+		log.Println(err.Error())
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
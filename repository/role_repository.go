@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+type RoleRepository interface {
+	FindRoleNamesByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]string, error)
+	FindPermissionNamesByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]string, error)
+	FindAll(ctx context.Context, tx *sql.Tx) ([]model.Role, error)
+	AssignRole(ctx context.Context, tx *sql.Tx, userId int, role string) (bool, error)
+	RevokeRole(ctx context.Context, tx *sql.Tx, userId int, role string) (bool, error)
+	CountUsersByRole(ctx context.Context, tx *sql.Tx, role string) (int, error)
+}
+
+type RoleRepositoryImpl struct {
+}
+
+func NewRoleRepository() *RoleRepositoryImpl {
+	return &RoleRepositoryImpl{}
+}
+
+func (r RoleRepositoryImpl) FindRoleNamesByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]string, error) {
+	query := `SELECT roles.name FROM user_roles JOIN roles ON roles.id = user_roles.role_id WHERE user_roles.user_id = ? ORDER BY roles.name`
+	return queryNames(ctx, tx, query, userId)
+}
+
+func (r RoleRepositoryImpl) FindPermissionNamesByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]string, error) {
+	query := `SELECT DISTINCT permissions.name FROM user_roles
+		JOIN role_permissions ON role_permissions.role_id = user_roles.role_id
+		JOIN permissions ON permissions.id = role_permissions.permission_id
+		WHERE user_roles.user_id = ? ORDER BY permissions.name`
+	return queryNames(ctx, tx, query, userId)
+}
+
+func (r RoleRepositoryImpl) FindAll(ctx context.Context, tx *sql.Tx) ([]model.Role, error) {
+	query := `SELECT roles.id, roles.name, roles.description, COALESCE(GROUP_CONCAT(permissions.name ORDER BY permissions.name), '')
+		FROM roles
+		LEFT JOIN role_permissions ON role_permissions.role_id = roles.id
+		LEFT JOIN permissions ON permissions.id = role_permissions.permission_id
+		GROUP BY roles.id, roles.name, roles.description
+		ORDER BY roles.id`
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, exceptions.NewInternalServerError()
+	}
+	defer rows.Close()
+
+	var roles []model.Role
+	for rows.Next() {
+		var role model.Role
+		var permissions string
+		err := rows.Scan(&role.Id, &role.Name, &role.Description, &permissions)
+		if err != nil {
+			return nil, exceptions.NewInternalServerError()
+		}
+		role.Permissions = splitNames(permissions)
+		roles = append(roles, role)
+	}
+
+	return roles, nil
+}
+
+// AssignRole reports false when the role does not exist or the user already
+// holds it.
+func (r RoleRepositoryImpl) AssignRole(ctx context.Context, tx *sql.Tx, userId int, role string) (bool, error) {
+	query := `INSERT IGNORE INTO user_roles (user_id, role_id, created_at) SELECT ?, id, ? FROM roles WHERE name = ?`
+	result, err := tx.ExecContext(ctx, query, userId, time.Now(), role)
+	if err != nil {
+		log.Println(err.Error())
+		return false, exceptions.NewInternalServerError()
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, exceptions.NewInternalServerError()
+	}
+
+	return affected > 0, nil
+}
+
+func (r RoleRepositoryImpl) RevokeRole(ctx context.Context, tx *sql.Tx, userId int, role string) (bool, error) {
+	query := `DELETE user_roles FROM user_roles JOIN roles ON roles.id = user_roles.role_id WHERE user_roles.user_id = ? AND roles.name = ?`
+	result, err := tx.ExecContext(ctx, query, userId, role)
+	if err != nil {
+		log.Println(err.Error())
+		return false, exceptions.NewInternalServerError()
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, exceptions.NewInternalServerError()
+	}
+
+	return affected > 0, nil
+}
+
+func queryNames(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, exceptions.NewInternalServerError()
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, exceptions.NewInternalServerError()
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func splitNames(joined string) []string {
+	if joined == "" {
+		return []string{}
+	}
+	return strings.Split(joined, ",")
+}
+
+func (r RoleRepositoryImpl) CountUsersByRole(ctx context.Context, tx *sql.Tx, role string) (int, error) {
+	query := `SELECT COUNT(*) FROM user_roles JOIN roles ON roles.id = user_roles.role_id WHERE roles.name = ?`
+	var count int
+	err := tx.QueryRowContext(ctx, query, role).Scan(&count)
+	if err != nil {
+		log.Println(err.Error())
+		return 0, exceptions.NewInternalServerError()
+	}
+
+	return count, nil
+}
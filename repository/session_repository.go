@@ -6,11 +6,21 @@ import (
 	"context"
 	"database/sql"
 	"log"
+	"time"
 )
 
 type SessionRepository interface {
 	Save(ctx context.Context, tx *sql.Tx, session *model.Session) (*model.Session, error)
 	FindByToken(ctx context.Context, tx *sql.Tx, token string) (*model.Session, error)
+	FindByTokenForShare(ctx context.Context, tx *sql.Tx, token string) (*model.Session, error)
+	FindById(ctx context.Context, tx *sql.Tx, id int) (*model.Session, error)
+	FindAllActiveByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]model.Session, error)
+	UpdateToken(ctx context.Context, tx *sql.Tx, session *model.Session) error
+	UpdateLastSeen(ctx context.Context, tx *sql.Tx, id int, ipAddress string, lastSeenAt time.Time) error
+	UpdateExpiresAt(ctx context.Context, tx *sql.Tx, id int, expiresAt time.Time) error
+	Revoke(ctx context.Context, tx *sql.Tx, id int) error
+	RevokeAllByUserId(ctx context.Context, tx *sql.Tx, userId int) error
+	RevokeAllByUserIdExcept(ctx context.Context, tx *sql.Tx, userId int, exceptId int) error
 }
 
 type SessionRepositoryImpl struct {
@@ -20,9 +30,24 @@ func NewSessionRepository() *SessionRepositoryImpl {
 	return &SessionRepositoryImpl{}
 }
 
+const sessionColumns = `id, user_id, token, expires_at, access_expires_at, revoked_at, created_at, last_seen_at, ip_address, user_agent, device_label, impersonator_id, impersonation_read_only`
+
+func scanSession(rows *sql.Rows) (*model.Session, error) {
+	var session model.Session
+	err := rows.Scan(&session.Id, &session.UserId, &session.Token, &session.ExpiresAt, &session.AccessExpiresAt, &session.RevokedAt,
+		&session.CreatedAt, &session.LastSeenAt, &session.IpAddress, &session.UserAgent, &session.DeviceLabel, &session.ImpersonatorId, &session.ImpersonationReadOnly)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	return &session, nil
+}
+
 func (s SessionRepositoryImpl) Save(ctx context.Context, tx *sql.Tx, session *model.Session) (*model.Session, error) {
-	query := `INSERT INTO sessions (id, user_id, token, expires_at) VALUES (NULL, ?, ?, ?)`
-	result, err := tx.ExecContext(ctx, query, session.UserId, session.Token, session.ExpiresAt)
+	query := `INSERT INTO sessions (id, user_id, token, expires_at, access_expires_at, created_at, last_seen_at, ip_address, user_agent, device_label, impersonator_id, impersonation_read_only)
+		VALUES (NULL, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.ExecContext(ctx, query, session.UserId, session.Token, session.ExpiresAt, session.AccessExpiresAt, session.CreatedAt,
+		session.LastSeenAt, session.IpAddress, session.UserAgent, session.DeviceLabel, session.ImpersonatorId, session.ImpersonationReadOnly)
 	if err != nil {
 		log.Println(err.Error())
 		return nil, exceptions.NewInternalServerError()
@@ -38,22 +63,134 @@ func (s SessionRepositoryImpl) Save(ctx context.Context, tx *sql.Tx, session *mo
 }
 
 func (s SessionRepositoryImpl) FindByToken(ctx context.Context, tx *sql.Tx, token string) (*model.Session, error) {
-	query := `SELECT id, user_id, token, expires_at FROM sessions WHERE token = ?`
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE token = ?`
 	rows, err := tx.QueryContext(ctx, query, token)
 	if err != nil {
 		return nil, exceptions.NewInternalServerError()
 	}
 	defer rows.Close()
 
-	var session model.Session
 	if !rows.Next() {
 		return nil, exceptions.NewNotFoundError()
 	}
 
-	err = rows.Scan(&session.Id, &session.UserId, &session.Token, &session.ExpiresAt)
+	return scanSession(rows)
+}
+
+// FindByTokenForShare reads the session under a shared lock, so it cannot be
+// changed by others until the transaction ends.
+func (s SessionRepositoryImpl) FindByTokenForShare(ctx context.Context, tx *sql.Tx, token string) (*model.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE token = ? LOCK IN SHARE MODE`
+	rows, err := tx.QueryContext(ctx, query, token)
 	if err != nil {
 		return nil, exceptions.NewInternalServerError()
 	}
+	defer rows.Close()
 
-	return &session, nil
+	if !rows.Next() {
+		return nil, exceptions.NewNotFoundError()
+	}
+
+	return scanSession(rows)
+}
+
+func (s SessionRepositoryImpl) FindById(ctx context.Context, tx *sql.Tx, id int) (*model.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE id = ?`
+	rows, err := tx.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, exceptions.NewNotFoundError()
+	}
+
+	return scanSession(rows)
+}
+
+func (s SessionRepositoryImpl) FindAllActiveByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]model.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ? ORDER BY last_seen_at DESC`
+	rows, err := tx.QueryContext(ctx, query, userId, time.Now())
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+	defer rows.Close()
+
+	var sessions []model.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+
+	return sessions, nil
+}
+
+func (s SessionRepositoryImpl) UpdateToken(ctx context.Context, tx *sql.Tx, session *model.Session) error {
+	query := `UPDATE sessions SET token = ?, access_expires_at = ?, expires_at = ?, last_seen_at = ? WHERE id = ?`
+	_, err := tx.ExecContext(ctx, query, session.Token, session.AccessExpiresAt, session.ExpiresAt, session.LastSeenAt, session.Id)
+	if err != nil {
+		log.Println(err.Error())
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
+
+func (s SessionRepositoryImpl) UpdateLastSeen(ctx context.Context, tx *sql.Tx, id int, ipAddress string, lastSeenAt time.Time) error {
+	query := `UPDATE sessions SET last_seen_at = ?, ip_address = ? WHERE id = ?`
+	_, err := tx.ExecContext(ctx, query, lastSeenAt, ipAddress, id)
+	if err != nil {
+		log.Println(err.Error())
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
+
+func (s SessionRepositoryImpl) UpdateExpiresAt(ctx context.Context, tx *sql.Tx, id int, expiresAt time.Time) error {
+	query := `UPDATE sessions SET expires_at = ? WHERE id = ?`
+	_, err := tx.ExecContext(ctx, query, expiresAt, id)
+	if err != nil {
+		log.Println(err.Error())
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
+
+func (s SessionRepositoryImpl) Revoke(ctx context.Context, tx *sql.Tx, id int) error {
+	query := `UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`
+	_, err := tx.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		log.Println(err.Error())
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
+
+func (s SessionRepositoryImpl) RevokeAllByUserId(ctx context.Context, tx *sql.Tx, userId int) error {
+	query := `UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`
+	_, err := tx.ExecContext(ctx, query, time.Now(), userId)
+	if err != nil {
+		log.Println(err.Error())
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
+
+func (s SessionRepositoryImpl) RevokeAllByUserIdExcept(ctx context.Context, tx *sql.Tx, userId int, exceptId int) error {
+	query := `UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id <> ? AND revoked_at IS NULL`
+	_, err := tx.ExecContext(ctx, query, time.Now(), userId, exceptId)
+	if err != nil {
+		log.Println(err.Error())
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
 }
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"context"
+	"database/sql"
+	"log"
+)
+
+type UserIdentityRepository interface {
+	Save(ctx context.Context, tx *sql.Tx, identity *model.UserIdentity) (*model.UserIdentity, error)
+	FindByProviderAndSubject(ctx context.Context, tx *sql.Tx, provider string, subject string) (*model.UserIdentity, error)
+	FindAllByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]model.UserIdentity, error)
+	DeleteByUserIdAndProvider(ctx context.Context, tx *sql.Tx, userId int, provider string) (bool, error)
+	DeleteAllByUserId(ctx context.Context, tx *sql.Tx, userId int) error
+}
+
+type UserIdentityRepositoryImpl struct {
+}
+
+func NewUserIdentityRepository() *UserIdentityRepositoryImpl {
+	return &UserIdentityRepositoryImpl{}
+}
+
+const userIdentityColumns = `id, user_id, provider, subject, email, created_at`
+
+func scanUserIdentity(rows *sql.Rows) (*model.UserIdentity, error) {
+	var identity model.UserIdentity
+	err := rows.Scan(&identity.Id, &identity.UserId, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	return &identity, nil
+}
+
+func (u UserIdentityRepositoryImpl) Save(ctx context.Context, tx *sql.Tx, identity *model.UserIdentity) (*model.UserIdentity, error) {
+	query := `INSERT INTO user_identities (user_id, provider, subject, email, created_at) VALUES (?, ?, ?, ?, ?)`
+	result, err := tx.ExecContext(ctx, query, identity.UserId, identity.Provider, identity.Subject, identity.Email, identity.CreatedAt)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	identity.Id = int(id)
+	return identity, nil
+}
+
+func (u UserIdentityRepositoryImpl) FindByProviderAndSubject(ctx context.Context, tx *sql.Tx, provider string, subject string) (*model.UserIdentity, error) {
+	query := `SELECT ` + userIdentityColumns + ` FROM user_identities WHERE provider = ? AND subject = ?`
+	rows, err := tx.QueryContext(ctx, query, provider, subject)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, exceptions.NewNotFoundError()
+	}
+
+	return scanUserIdentity(rows)
+}
+
+func (u UserIdentityRepositoryImpl) FindAllByUserId(ctx context.Context, tx *sql.Tx, userId int) ([]model.UserIdentity, error) {
+	query := `SELECT ` + userIdentityColumns + ` FROM user_identities WHERE user_id = ? ORDER BY created_at`
+	rows, err := tx.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+	defer rows.Close()
+
+	var identities []model.UserIdentity
+	for rows.Next() {
+		identity, err := scanUserIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, *identity)
+	}
+
+	return identities, nil
+}
+
+func (u UserIdentityRepositoryImpl) DeleteByUserIdAndProvider(ctx context.Context, tx *sql.Tx, userId int, provider string) (bool, error) {
+	query := `DELETE FROM user_identities WHERE user_id = ? AND provider = ?`
+	result, err := tx.ExecContext(ctx, query, userId, provider)
+	if err != nil {
+		return false, exceptions.NewInternalServerError()
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, exceptions.NewInternalServerError()
+	}
+
+	return affected > 0, nil
+}
+
+func (u UserIdentityRepositoryImpl) DeleteAllByUserId(ctx context.Context, tx *sql.Tx, userId int) error {
+	query := `DELETE FROM user_identities WHERE user_id = ?`
+	_, err := tx.ExecContext(ctx, query, userId)
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
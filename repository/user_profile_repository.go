@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/helpers"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+)
+
+type UserProfileRepository interface {
+	Save(ctx context.Context, tx *sql.Tx, profile *model.UserProfile) (*model.UserProfile, error)
+	FindByUserId(ctx context.Context, tx *sql.Tx, userId int) (*model.UserProfile, error)
+	DeleteByUserId(ctx context.Context, tx *sql.Tx, userId int) error
+}
+
+type UserProfileRepositoryImpl struct {
+}
+
+func NewUserProfileRepository() *UserProfileRepositoryImpl {
+	return &UserProfileRepositoryImpl{}
+}
+
+// Save creates the profile or replaces every field of an existing one.
+func (u UserProfileRepositoryImpl) Save(ctx context.Context, tx *sql.Tx, profile *model.UserProfile) (*model.UserProfile, error) {
+	allergies, _ := json.Marshal(helpers.NonNilStrings(profile.Allergies))
+	chronicConditions, _ := json.Marshal(helpers.NonNilStrings(profile.ChronicConditions))
+	currentMedications, _ := json.Marshal(helpers.NonNilStrings(profile.CurrentMedications))
+
+	var sex *string
+	if profile.Sex != "" {
+		sex = &profile.Sex
+	}
+
+	query := `INSERT INTO user_profiles (user_id, display_name, avatar_url, date_of_birth, sex, allergies, chronic_conditions, current_medications, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE display_name = VALUES(display_name), avatar_url = VALUES(avatar_url), date_of_birth = VALUES(date_of_birth), sex = VALUES(sex),
+			allergies = VALUES(allergies), chronic_conditions = VALUES(chronic_conditions), current_medications = VALUES(current_medications), updated_at = VALUES(updated_at)`
+	_, err := tx.ExecContext(ctx, query, profile.UserId, profile.DisplayName, profile.AvatarUrl, profile.DateOfBirth, sex,
+		allergies, chronicConditions, currentMedications, profile.CreatedAt, profile.UpdatedAt)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	return profile, nil
+}
+
+func (u UserProfileRepositoryImpl) FindByUserId(ctx context.Context, tx *sql.Tx, userId int) (*model.UserProfile, error) {
+	query := `SELECT user_id, display_name, avatar_url, date_of_birth, sex, allergies, chronic_conditions, current_medications, created_at, updated_at
+		FROM user_profiles WHERE user_id = ?`
+	rows, err := tx.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, exceptions.NewNotFoundError()
+	}
+
+	var profile model.UserProfile
+	var sex sql.NullString
+	var allergies, chronicConditions, currentMedications []byte
+	err = rows.Scan(&profile.UserId, &profile.DisplayName, &profile.AvatarUrl, &profile.DateOfBirth, &sex,
+		&allergies, &chronicConditions, &currentMedications, &profile.CreatedAt, &profile.UpdatedAt)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	profile.Sex = sex.String
+	if json.Unmarshal(allergies, &profile.Allergies) != nil ||
+		json.Unmarshal(chronicConditions, &profile.ChronicConditions) != nil ||
+		json.Unmarshal(currentMedications, &profile.CurrentMedications) != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	return &profile, nil
+}
+
+func (u UserProfileRepositoryImpl) DeleteByUserId(ctx context.Context, tx *sql.Tx, userId int) error {
+	query := `DELETE FROM user_profiles WHERE user_id = ?`
+	_, err := tx.ExecContext(ctx, query, userId)
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
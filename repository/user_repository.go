@@ -6,6 +6,8 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"time"
 )
 
 type UserRepository interface {
@@ -13,6 +15,14 @@ type UserRepository interface {
 	FindByEmail(ctx context.Context, tx *sql.Tx, email string) (*model.User, error)
 	FindById(ctx context.Context, tx *sql.Tx, id int) (*model.User, error)
 	UpdatePassword(ctx context.Context, tx *sql.Tx, email string, password string) (*model.User, error)
+	ReplacePasswordHash(ctx context.Context, tx *sql.Tx, id int, oldHash string, newHash string) error
+	MarkEmailVerified(ctx context.Context, tx *sql.Tx, id int, verifiedAt time.Time) error
+	UpdateTotp(ctx context.Context, tx *sql.Tx, id int, secret string, enabledAt *time.Time) error
+	FindAllWithTotpSecretOutside(ctx context.Context, tx *sql.Tx, prefix string) ([]model.User, error)
+	ReplaceTotpSecret(ctx context.Context, tx *sql.Tx, id int, oldSecret string, newSecret string) error
+	RequirePasswordReset(ctx context.Context, tx *sql.Tx, id int, requiredAt time.Time) error
+	UpdateProvider(ctx context.Context, tx *sql.Tx, id int, provider string) error
+	Anonymize(ctx context.Context, tx *sql.Tx, id int) error
 }
 
 type UserRepositoryImpl struct {
@@ -22,9 +32,21 @@ func NewUserRepository() *UserRepositoryImpl {
 	return &UserRepositoryImpl{}
 }
 
+const userColumns = `id, email, password, provider, email_verified_at, totp_secret, totp_enabled_at, password_reset_required_at`
+
+func scanUser(rows *sql.Rows) (*model.User, error) {
+	var user model.User
+	err := rows.Scan(&user.Id, &user.Email, &user.Password, &user.Provider, &user.EmailVerifiedAt, &user.TotpSecret, &user.TotpEnabledAt, &user.PasswordResetRequiredAt)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	return &user, nil
+}
+
 func (u UserRepositoryImpl) Save(ctx context.Context, tx *sql.Tx, user *model.User) (*model.User, error) {
-	query := `INSERT INTO users (id, email, password, provider) VALUES (NULL, ?, ?, ?)`
-	result, err := tx.ExecContext(ctx, query, user.Email, user.Password, user.Provider)
+	query := `INSERT INTO users (id, email, password, provider, email_verified_at) VALUES (NULL, ?, ?, ?, ?)`
+	result, err := tx.ExecContext(ctx, query, user.Email, user.Password, user.Provider, user.EmailVerifiedAt)
 	if err != nil {
 		return nil, exceptions.NewInternalServerError()
 	}
@@ -39,45 +61,33 @@ func (u UserRepositoryImpl) Save(ctx context.Context, tx *sql.Tx, user *model.Us
 }
 
 func (u UserRepositoryImpl) FindByEmail(ctx context.Context, tx *sql.Tx, email string) (*model.User, error) {
-	query := `SELECT id, email, password, provider FROM users WHERE email = ?`
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = ?`
 	rows, err := tx.QueryContext(ctx, query, email)
 	if err != nil {
 		return nil, exceptions.NewInternalServerError()
 	}
 	defer rows.Close()
 
-	var user model.User
 	if !rows.Next() {
 		return nil, exceptions.NewNotFoundError()
 	}
 
-	err = rows.Scan(&user.Id, &user.Email, &user.Password, &user.Provider)
-	if err != nil {
-		return nil, exceptions.NewInternalServerError()
-	}
-
-	return &user, nil
+	return scanUser(rows)
 }
 
 func (u UserRepositoryImpl) FindById(ctx context.Context, tx *sql.Tx, id int) (*model.User, error) {
-	query := `SELECT id, email, password, provider FROM users WHERE id = ?`
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = ?`
 	rows, err := tx.QueryContext(ctx, query, id)
 	if err != nil {
 		return nil, exceptions.NewInternalServerError()
 	}
 	defer rows.Close()
 
-	var user model.User
 	if !rows.Next() {
 		return nil, exceptions.NewNotFoundError()
 	}
 
-	err = rows.Scan(&user.Id, &user.Email, &user.Password, &user.Provider)
-	if err != nil {
-		return nil, exceptions.NewInternalServerError()
-	}
-
-	return &user, nil
+	return scanUser(rows)
 }
 
 func (u UserRepositoryImpl) UpdatePassword(ctx context.Context, tx *sql.Tx, email string, password string) (*model.User, error) {
@@ -88,7 +98,7 @@ func (u UserRepositoryImpl) UpdatePassword(ctx context.Context, tx *sql.Tx, emai
 		return nil, err
 	}
 
-	query := "UPDATE users SET password = ? WHERE email = ?"
+	query := "UPDATE users SET password = ?, password_reset_required_at = NULL WHERE email = ?"
 	_, err = tx.ExecContext(ctx, query, password, email)
 
 	if err != nil {
@@ -96,5 +106,104 @@ func (u UserRepositoryImpl) UpdatePassword(ctx context.Context, tx *sql.Tx, emai
 	}
 
 	user.Password = password
+	user.PasswordResetRequiredAt = nil
 	return user, nil
 }
+
+// ReplacePasswordHash swaps the stored hash for one of the same password. It
+// does nothing when the password changed since oldHash was read.
+func (u UserRepositoryImpl) ReplacePasswordHash(ctx context.Context, tx *sql.Tx, id int, oldHash string, newHash string) error {
+	query := "UPDATE users SET password = ? WHERE id = ? AND password = ?"
+	_, err := tx.ExecContext(ctx, query, newHash, id, oldHash)
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
+
+func (u UserRepositoryImpl) MarkEmailVerified(ctx context.Context, tx *sql.Tx, id int, verifiedAt time.Time) error {
+	query := "UPDATE users SET email_verified_at = ? WHERE id = ? AND email_verified_at IS NULL"
+	_, err := tx.ExecContext(ctx, query, verifiedAt, id)
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
+
+func (u UserRepositoryImpl) UpdateTotp(ctx context.Context, tx *sql.Tx, id int, secret string, enabledAt *time.Time) error {
+	query := "UPDATE users SET totp_secret = ?, totp_enabled_at = ? WHERE id = ?"
+	_, err := tx.ExecContext(ctx, query, secret, enabledAt, id)
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
+
+// FindAllWithTotpSecretOutside returns the users holding a TOTP secret that
+// does not start with prefix.
+func (u UserRepositoryImpl) FindAllWithTotpSecretOutside(ctx context.Context, tx *sql.Tx, prefix string) ([]model.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE totp_secret != '' AND LEFT(totp_secret, CHAR_LENGTH(?)) != ?`
+	rows, err := tx.QueryContext(ctx, query, prefix, prefix)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+	defer rows.Close()
+
+	var users []model.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+
+	return users, nil
+}
+
+// ReplaceTotpSecret swaps the stored secret for another form of the same
+// secret. It does nothing when the secret changed since oldSecret was read.
+func (u UserRepositoryImpl) ReplaceTotpSecret(ctx context.Context, tx *sql.Tx, id int, oldSecret string, newSecret string) error {
+	query := "UPDATE users SET totp_secret = ? WHERE id = ? AND totp_secret = ?"
+	_, err := tx.ExecContext(ctx, query, newSecret, id, oldSecret)
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
+
+func (u UserRepositoryImpl) RequirePasswordReset(ctx context.Context, tx *sql.Tx, id int, requiredAt time.Time) error {
+	query := "UPDATE users SET password_reset_required_at = ? WHERE id = ?"
+	_, err := tx.ExecContext(ctx, query, requiredAt, id)
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
+
+func (u UserRepositoryImpl) UpdateProvider(ctx context.Context, tx *sql.Tx, id int, provider string) error {
+	query := "UPDATE users SET provider = ? WHERE id = ?"
+	_, err := tx.ExecContext(ctx, query, provider, id)
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
+
+// Anonymize frees the email and strips every credential so the account can no
+// longer be used while its data is purged in the background.
+func (u UserRepositoryImpl) Anonymize(ctx context.Context, tx *sql.Tx, id int) error {
+	query := "UPDATE users SET email = ?, password = '', totp_secret = '', totp_enabled_at = NULL WHERE id = ?"
+	_, err := tx.ExecContext(ctx, query, fmt.Sprintf("deleted-%d@deleted.invalid", id), id)
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
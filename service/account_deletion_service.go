@@ -0,0 +1,347 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/helpers"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"time"
+)
+
+type AccountDeletionService interface {
+	RequestDeletion(ctx context.Context, user *model.User, session *model.Session, req model.DeleteAccountRequest) (*model.AccountDeletionReceipt, error)
+	GetReceipt(ctx context.Context, id string) (*model.AccountDeletionReceipt, error)
+	RunWorker(ctx context.Context)
+}
+
+type AccountDeletionServiceImpl struct {
+	AccountDeletionRepo repository.AccountDeletionRepository
+	UserRepo            repository.UserRepository
+	SessionRepo         repository.SessionRepository
+	RecoveryCodeRepo    repository.RecoveryCodeRepository
+	UserIdentityRepo    repository.UserIdentityRepository
+	ApiKeyRepo          repository.ApiKeyRepository
+	ComplaintRepo       repository.ComplaintRepository
+	DB                  *sql.DB
+	Cnf                 *config.Config
+	RedisClient         *redis.Client
+	AIClient            *config.AIClient
+	AWSClient           *config.AWSClient
+	SessionCache        *SessionCache
+	PasswordHasher      helpers.PasswordHasher
+	Keyring             *config.Keyring
+}
+
+func NewAccountDeletionService(
+	accountDeletionRepo repository.AccountDeletionRepository,
+	userRepo repository.UserRepository,
+	sessionRepo repository.SessionRepository,
+	recoveryCodeRepo repository.RecoveryCodeRepository,
+	userIdentityRepo repository.UserIdentityRepository,
+	apiKeyRepo repository.ApiKeyRepository,
+	complaintRepo repository.ComplaintRepository,
+	DB *sql.DB,
+	cnf *config.Config,
+	redisClient *redis.Client,
+	aiClient *config.AIClient,
+	awsClient *config.AWSClient,
+	sessionCache *SessionCache,
+	passwordHasher helpers.PasswordHasher,
+	keyring *config.Keyring,
+) *AccountDeletionServiceImpl {
+	return &AccountDeletionServiceImpl{AccountDeletionRepo: accountDeletionRepo, UserRepo: userRepo, SessionRepo: sessionRepo, RecoveryCodeRepo: recoveryCodeRepo, UserIdentityRepo: userIdentityRepo, ApiKeyRepo: apiKeyRepo, ComplaintRepo: complaintRepo, DB: DB, Cnf: cnf, RedisClient: redisClient, AIClient: aiClient, AWSClient: awsClient, SessionCache: sessionCache, PasswordHasher: passwordHasher, Keyring: keyring}
+}
+
+func toAccountDeletionReceipt(deletion *model.AccountDeletion) *model.AccountDeletionReceipt {
+	return &model.AccountDeletionReceipt{
+		Id:                deletion.Id,
+		Status:            deletion.Status,
+		ComplaintsDeleted: deletion.ComplaintsDeleted,
+		ImagesDeleted:     deletion.ImagesDeleted,
+		AiFilesDeleted:    deletion.AiFilesDeleted,
+		RequestedAt:       deletion.RequestedAt,
+		CompletedAt:       deletion.CompletedAt,
+	}
+}
+
+// RequestDeletion re-authenticates the user, locks the account out right away
+// and queues the purge of everything it owns. The returned receipt id is the
+// only handle left on the request once the account is gone.
+func (a AccountDeletionServiceImpl) RequestDeletion(ctx context.Context, user *model.User, session *model.Session, req model.DeleteAccountRequest) (*model.AccountDeletionReceipt, error) {
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	// the user from the request context carries no credentials
+	user, err = a.UserRepo.FindById(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if user.Password != "" {
+		if !a.PasswordHasher.Verify(req.Password, user.Password) {
+			_ = tx.Rollback()
+			return nil, exceptions.NewUnauthorizedError("Password is incorrect")
+		}
+	} else if time.Since(session.CreatedAt) > a.Cnf.Env.GetDuration("ACCOUNT_DELETION_RECENT_LOGIN") {
+		// accounts without a password prove themselves by a fresh sign in
+		_ = tx.Rollback()
+		return nil, exceptions.NewUnauthorizedError("Please sign in again before deleting your account")
+	}
+
+	if user.TotpEnabledAt != nil {
+		ok, err := verifySecondFactor(ctx, tx, a.RedisClient, a.Keyring, a.RecoveryCodeRepo, user, req.Code)
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+
+		if !ok {
+			_ = tx.Rollback()
+			return nil, exceptions.NewUnauthorizedError("Invalid two-factor code")
+		}
+	}
+
+	err = a.SessionRepo.RevokeAllByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	err = a.ApiKeyRepo.RevokeAllByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	err = a.UserRepo.Anonymize(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	// social logins must stop resolving to the account straight away
+	err = a.UserIdentityRepo.DeleteAllByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	deletion, err := a.AccountDeletionRepo.Save(ctx, tx, &model.AccountDeletion{
+		Id:          uuid.NewString(),
+		UserId:      user.Id,
+		Status:      repository.AccountDeletionPending,
+		RequestedAt: time.Now(),
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	err = a.SessionCache.Forget(ctx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return toAccountDeletionReceipt(deletion), nil
+}
+
+func (a AccountDeletionServiceImpl) GetReceipt(ctx context.Context, id string) (*model.AccountDeletionReceipt, error) {
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	deletion, err := a.AccountDeletionRepo.FindById(ctx, tx, id)
+	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
+		return nil, exceptions.NewHttpNotFoundError("Deletion receipt not found")
+	} else if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	return toAccountDeletionReceipt(deletion), nil
+}
+
+// RunWorker polls for queued deletions until the context is cancelled. Jobs
+// are claimed with a conditional update, so it is safe to run one worker per
+// prefork child.
+func (a AccountDeletionServiceImpl) RunWorker(ctx context.Context) {
+	ticker := time.NewTicker(a.Cnf.Env.GetDuration("ACCOUNT_DELETION_POLL_INTERVAL"))
+	defer ticker.Stop()
+
+	for {
+		a.processPending(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a AccountDeletionServiceImpl) processPending(ctx context.Context) {
+	// a job running for longer than this belongs to a worker that died
+	staleBefore := time.Now().Add(-a.Cnf.Env.GetDuration("ACCOUNT_DELETION_STALE_AFTER"))
+
+	tx, err := a.DB.Begin()
+	if err != nil {
+		log.Println("error while begin account deletion poll", err)
+		return
+	}
+
+	deletions, err := a.AccountDeletionRepo.FindRunnable(ctx, tx, a.Cnf.Env.GetInt("ACCOUNT_DELETION_MAX_ATTEMPTS"), staleBefore)
+	if err != nil {
+		_ = tx.Rollback()
+		log.Println("error while find account deletions", err)
+		return
+	}
+
+	_ = tx.Commit()
+
+	for i := range deletions {
+		deletion := &deletions[i]
+
+		claimed, err := a.claim(ctx, deletion, staleBefore)
+		if err != nil || !claimed {
+			continue
+		}
+
+		err = a.process(ctx, deletion)
+		if err != nil {
+			log.Printf("account deletion %s failed on attempt %d: %v", deletion.Id, deletion.Attempts, err)
+			a.fail(ctx, deletion, err)
+		}
+	}
+}
+
+func (a AccountDeletionServiceImpl) claim(ctx context.Context, deletion *model.AccountDeletion, staleBefore time.Time) (bool, error) {
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return false, exceptions.NewInternalServerError()
+	}
+
+	claimed, err := a.AccountDeletionRepo.Claim(ctx, tx, deletion, staleBefore)
+	if err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	_ = tx.Commit()
+
+	return claimed, nil
+}
+
+// process removes the uploaded files first and the rows last, so a failed
+// attempt never loses track of objects that still exist. Deleting an S3
+// object that is already gone succeeds, which keeps retries idempotent.
+func (a AccountDeletionServiceImpl) process(ctx context.Context, deletion *model.AccountDeletion) error {
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	complaints, err := a.ComplaintRepo.FindAll(ctx, tx, deletion.UserId)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_ = tx.Commit()
+
+	bucket := a.Cnf.Env.GetString("AWS_BUCKET_NAME")
+	imagesDeleted, aiFilesDeleted := 0, 0
+	for _, complaint := range complaints {
+		if complaint.ImageUrl != "" {
+			key, err := helpers.S3KeyFromLocation(complaint.ImageUrl, bucket)
+			if err != nil {
+				return fmt.Errorf("parse image url of complaint %s: %w", complaint.Id, err)
+			}
+
+			err = helpers.DeleteS3(ctx, a.AWSClient.S3Client, key, bucket)
+			if err != nil {
+				return fmt.Errorf("delete image of complaint %s: %w", complaint.Id, err)
+			}
+			imagesDeleted++
+		}
+
+		// gemini expires uploaded files on its own after 48 hours, so a
+		// failure here is logged instead of blocking the purge
+		if complaint.GeminiFileName != "" {
+			err = helpers.DeleteFromGemini(ctx, a.AIClient.Genai, complaint.GeminiFileName)
+			if err != nil {
+				log.Printf("error while delete gemini file %s: %v", complaint.GeminiFileName, err)
+				continue
+			}
+			aiFilesDeleted++
+		}
+	}
+
+	tx, err = a.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = a.AccountDeletionRepo.PurgeUser(ctx, tx, deletion.UserId)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	now := time.Now()
+	deletion.Status = repository.AccountDeletionCompleted
+	deletion.LastError = ""
+	deletion.ComplaintsDeleted = len(complaints)
+	deletion.ImagesDeleted = imagesDeleted
+	deletion.AiFilesDeleted = aiFilesDeleted
+	deletion.CompletedAt = &now
+
+	err = a.AccountDeletionRepo.Update(ctx, tx, deletion)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (a AccountDeletionServiceImpl) fail(ctx context.Context, deletion *model.AccountDeletion, cause error) {
+	lastError := cause.Error()
+	if len(lastError) > 1024 {
+		lastError = lastError[:1024]
+	}
+
+	deletion.Status = repository.AccountDeletionFailed
+	deletion.LastError = lastError
+
+	tx, err := a.DB.Begin()
+	if err != nil {
+		log.Println("error while begin account deletion update", err)
+		return
+	}
+
+	err = a.AccountDeletionRepo.Update(ctx, tx, deletion)
+	if err != nil {
+		_ = tx.Rollback()
+		log.Println("error while update account deletion", err)
+		return
+	}
+
+	_ = tx.Commit()
+}
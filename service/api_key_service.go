@@ -0,0 +1,163 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/helpers"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/repository"
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyPrefix        = "evia_"
+	apiKeyDisplayLength = 12
+)
+
+type ApiKeyService interface {
+	Create(ctx context.Context, user *model.User, req model.CreateApiKeyRequest, client model.ClientInfo) (*model.CreateApiKeyResponse, error)
+	GetAll(ctx context.Context, user *model.User) ([]model.ApiKeyResponse, error)
+	Revoke(ctx context.Context, user *model.User, apiKeyId int, client model.ClientInfo) error
+}
+
+type ApiKeyServiceImpl struct {
+	ApiKeyRepo repository.ApiKeyRepository
+	DB         *sql.DB
+	Validate   *validator.Validate
+	Cnf        *config.Config
+	AuditLog   *AuditLog
+}
+
+func NewApiKeyService(apiKeyRepo repository.ApiKeyRepository, DB *sql.DB, validate *validator.Validate, cnf *config.Config, auditLog *AuditLog) *ApiKeyServiceImpl {
+	return &ApiKeyServiceImpl{ApiKeyRepo: apiKeyRepo, DB: DB, Validate: validate, Cnf: cnf, AuditLog: auditLog}
+}
+
+func toApiKeyResponse(apiKey *model.ApiKey) model.ApiKeyResponse {
+	return model.ApiKeyResponse{
+		Id:         apiKey.Id,
+		Name:       apiKey.Name,
+		Prefix:     apiKey.Prefix,
+		Scopes:     apiKey.Scopes,
+		ExpiresAt:  apiKey.ExpiresAt,
+		LastUsedAt: apiKey.LastUsedAt,
+		CreatedAt:  apiKey.CreatedAt,
+	}
+}
+
+func (a ApiKeyServiceImpl) Create(ctx context.Context, user *model.User, req model.CreateApiKeyRequest, client model.ClientInfo) (*model.CreateApiKeyResponse, error) {
+	err := a.Validate.Struct(req)
+	if err != nil {
+		return nil, exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+	}
+
+	ttl := a.Cnf.Env.GetDuration("API_KEY_DEFAULT_TTL")
+	if req.ExpiresInDays > 0 {
+		ttl = time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	}
+	if maxTtl := a.Cnf.Env.GetDuration("API_KEY_MAX_TTL"); ttl > maxTtl {
+		return nil, exceptions.NewBadRequestError(fmt.Sprintf("API keys cannot live longer than %d days", int(maxTtl.Hours()/24)))
+	}
+
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	apiKeys, err := a.ApiKeyRepo.FindAllActiveByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if len(apiKeys) >= a.Cnf.Env.GetInt("API_KEY_MAX_PER_USER") {
+		_ = tx.Rollback()
+		return nil, exceptions.NewHttpConflictError("Too many active API keys, revoke one first")
+	}
+
+	secret, err := helpers.GenerateRandomToken(32)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, exceptions.NewInternalServerError()
+	}
+	key := apiKeyPrefix + secret
+
+	scopes := slices.Clone(req.Scopes)
+	slices.Sort(scopes)
+
+	now := time.Now()
+	apiKey, err := a.ApiKeyRepo.Save(ctx, tx, &model.ApiKey{
+		UserId:    user.Id,
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    key[:apiKeyDisplayLength],
+		KeyHash:   helpers.HashToken(key),
+		Scopes:    slices.Compact(scopes),
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	a.AuditLog.Record(ctx, model.AuditApiKeyCreated, model.AuditSuccess, user, "", client,
+		fmt.Sprintf("key %d (%s), scopes %s", apiKey.Id, apiKey.Prefix, strings.Join(apiKey.Scopes, " ")))
+
+	return &model.CreateApiKeyResponse{
+		Key:    key,
+		ApiKey: toApiKeyResponse(apiKey),
+	}, nil
+}
+
+func (a ApiKeyServiceImpl) GetAll(ctx context.Context, user *model.User) ([]model.ApiKeyResponse, error) {
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	apiKeys, err := a.ApiKeyRepo.FindAllActiveByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	apiKeyResponses := []model.ApiKeyResponse{}
+	for _, apiKey := range apiKeys {
+		apiKeyResponses = append(apiKeyResponses, toApiKeyResponse(&apiKey))
+	}
+
+	return apiKeyResponses, nil
+}
+
+func (a ApiKeyServiceImpl) Revoke(ctx context.Context, user *model.User, apiKeyId int, client model.ClientInfo) error {
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	revoked, err := a.ApiKeyRepo.Revoke(ctx, tx, user.Id, apiKeyId)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if !revoked {
+		_ = tx.Rollback()
+		return exceptions.NewHttpNotFoundError("API key not found")
+	}
+
+	_ = tx.Commit()
+
+	a.AuditLog.Record(ctx, model.AuditApiKeyRevoked, model.AuditSuccess, user, "", client, fmt.Sprintf("key %d", apiKeyId))
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/repository"
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// AuditLog writes security events in a transaction of its own, so failed
+// attempts are kept even when the caller rolls back. Recording never fails
+// the request; errors are only logged.
+type AuditLog struct {
+	AuditEventRepo repository.AuditEventRepository
+	DB             *sql.DB
+}
+
+func NewAuditLog(auditEventRepo repository.AuditEventRepository, DB *sql.DB) *AuditLog {
+	return &AuditLog{AuditEventRepo: auditEventRepo, DB: DB}
+}
+
+func (a *AuditLog) Record(ctx context.Context, event string, outcome string, user *model.User, email string, client model.ClientInfo, detail string) {
+	auditEvent := &model.AuditEvent{
+		Email:     email,
+		Event:     event,
+		Outcome:   outcome,
+		IpAddress: client.IpAddress,
+		UserAgent: client.UserAgent,
+		Detail:    detail,
+		CreatedAt: time.Now(),
+	}
+	if user != nil {
+		auditEvent.UserId = &user.Id
+		auditEvent.Email = user.Email
+	}
+
+	tx, err := a.DB.Begin()
+	if err != nil {
+		log.Println("error while begin audit event transaction", err)
+		return
+	}
+
+	_, err = a.AuditEventRepo.Save(ctx, tx, auditEvent)
+	if err != nil {
+		_ = tx.Rollback()
+		log.Printf("error while record %s audit event: %v", event, err)
+		return
+	}
+
+	_ = tx.Commit()
+}
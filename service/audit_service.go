@@ -0,0 +1,104 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/repository"
+	"context"
+	"database/sql"
+	"github.com/go-playground/validator/v10"
+	"time"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+type AuditService interface {
+	GetMine(ctx context.Context, user *model.User, query model.AuditEventQuery) (*model.AuditEventsResponse, error)
+	Search(ctx context.Context, query model.AuditEventQuery) (*model.AuditEventsResponse, error)
+}
+
+type AuditServiceImpl struct {
+	AuditEventRepo repository.AuditEventRepository
+	DB             *sql.DB
+	Validate       *validator.Validate
+}
+
+func NewAuditService(auditEventRepo repository.AuditEventRepository, DB *sql.DB, validate *validator.Validate) *AuditServiceImpl {
+	return &AuditServiceImpl{AuditEventRepo: auditEventRepo, DB: DB, Validate: validate}
+}
+
+// GetMine lists the security history of the user; filters on other users
+// are ignored.
+func (a AuditServiceImpl) GetMine(ctx context.Context, user *model.User, query model.AuditEventQuery) (*model.AuditEventsResponse, error) {
+	query.UserId = user.Id
+	query.Email = ""
+	return a.Search(ctx, query)
+}
+
+func (a AuditServiceImpl) Search(ctx context.Context, query model.AuditEventQuery) (*model.AuditEventsResponse, error) {
+	err := a.Validate.Struct(query)
+	if err != nil {
+		return nil, exceptions.NewFailedValidationError(query, err.(validator.ValidationErrors))
+	}
+
+	filter := model.AuditEventFilter{
+		Email:     query.Email,
+		Event:     query.Event,
+		Outcome:   query.Outcome,
+		IpAddress: query.IpAddress,
+		BeforeId:  query.Before,
+		Limit:     query.Limit,
+	}
+	if query.UserId != 0 {
+		filter.UserId = &query.UserId
+	}
+	if query.From != "" {
+		from, _ := time.Parse(time.RFC3339, query.From)
+		filter.From = &from
+	}
+	if query.To != "" {
+		to, _ := time.Parse(time.RFC3339, query.To)
+		filter.To = &to
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditPageSize
+	} else if filter.Limit > maxAuditPageSize {
+		filter.Limit = maxAuditPageSize
+	}
+
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	events, err := a.AuditEventRepo.FindAll(ctx, tx, filter)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	resp := &model.AuditEventsResponse{Events: make([]model.AuditEventResponse, 0, len(events))}
+	for _, event := range events {
+		resp.Events = append(resp.Events, model.AuditEventResponse{
+			Id:        event.Id,
+			UserId:    event.UserId,
+			Email:     event.Email,
+			Event:     event.Event,
+			Outcome:   event.Outcome,
+			IpAddress: event.IpAddress,
+			UserAgent: event.UserAgent,
+			Detail:    event.Detail,
+			CreatedAt: event.CreatedAt,
+		})
+	}
+	if len(events) == filter.Limit {
+		resp.NextBefore = events[len(events)-1].Id
+	}
+
+	return resp, nil
+}
@@ -8,6 +8,7 @@ import (
 	"akmmp241/dinamcom-2024/dinacom-go-rest/repository"
 	"bytes"
 	"context"
+	"crypto/hmac"
 	"database/sql"
 	_ "embed"
 	"encoding/json"
@@ -16,59 +17,325 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/oauth2"
 	"html/template"
 	"log"
-	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
 //go:embed mail-templates/send-otp.html
 var OTPTemplateEmail string
 
+//go:embed mail-templates/reset-password.html
+var ResetPasswordTemplateEmail string
+
+//go:embed mail-templates/verify-email.html
+var VerifyEmailTemplateEmail string
+
+//go:embed mail-templates/unlock-account.html
+var UnlockAccountTemplateEmail string
+
+//go:embed mail-templates/magic-link.html
+var MagicLinkTemplateEmail string
+
+//go:embed mail-templates/new-device-login.html
+var NewDeviceLoginTemplateEmail string
+
 const (
-	EmailProvider  = "email"
-	GoogleProvider = "google"
+	EmailProvider = "email"
 )
 
 type AuthService interface {
-	Register(ctx context.Context, req model.RegisterRequest) (*model.RegisterResponse, error)
-	Login(ctx context.Context, req model.LoginRequest) (*model.LoginResponse, error)
+	Register(ctx context.Context, req model.RegisterRequest, client model.ClientInfo) (*model.RegisterResponse, error)
+	Login(ctx context.Context, req model.LoginRequest, client model.ClientInfo) (*model.LoginResponse, error)
 	Me(ctx context.Context, token string) (*model.MeResponse, error)
-	ForgetPassword(ctx context.Context, req model.ForgetPasswordRequest) error
-	VerifyForgetPasswordOtp(ctx context.Context, req model.VerifyForgetPasswordOtpRequest) (*model.VerifyForgetPasswordOtpResponse, error)
-	ResetPassword(ctx context.Context, req model.ResetPasswordRequest) (*model.ResetPasswordResponse, error)
-	GoogleCallback(ctx context.Context, req model.GoogleCallbackRequest) (*model.LoginResponse, error)
+	ForgetPassword(ctx context.Context, req model.ForgetPasswordRequest, client model.ClientInfo) error
+	VerifyForgetPasswordOtp(ctx context.Context, req model.VerifyForgetPasswordOtpRequest, client model.ClientInfo) (*model.VerifyForgetPasswordOtpResponse, error)
+	ResetPassword(ctx context.Context, req model.ResetPasswordRequest, client model.ClientInfo) (*model.ResetPasswordResponse, error)
+	OauthLogin(ctx context.Context, provider string) (string, string, error)
+	LinkIdentity(ctx context.Context, user *model.User, provider string) (string, string, error)
+	OauthCallback(ctx context.Context, provider string, req model.OauthCallbackRequest, client model.ClientInfo) (*model.LoginResponse, *model.IdentityResponse, error)
+	GetIdentities(ctx context.Context, user *model.User) (*model.IdentitiesResponse, error)
+	UnlinkIdentity(ctx context.Context, user *model.User, provider string) error
+	Refresh(ctx context.Context, req model.RefreshTokenRequest, client model.ClientInfo) (*model.RefreshTokenResponse, error)
+	Logout(ctx context.Context, user *model.User, session *model.Session, client model.ClientInfo) error
+	LogoutAll(ctx context.Context, user *model.User, client model.ClientInfo) error
+	GetSessions(ctx context.Context, user *model.User, current *model.Session) ([]model.SessionResponse, error)
+	RevokeSession(ctx context.Context, user *model.User, sessionId int, client model.ClientInfo) error
+	VerifyEmail(ctx context.Context, token string) error
+	ResendVerificationEmail(ctx context.Context, user *model.User) error
+	LoginTwoFactor(ctx context.Context, req model.LoginTwoFactorRequest, client model.ClientInfo) (*model.LoginResponse, error)
+	SendMagicLink(ctx context.Context, req model.MagicLinkRequest) error
+	MagicLinkLogin(ctx context.Context, req model.MagicLinkLoginRequest, client model.ClientInfo) (*model.LoginResponse, error)
+	UnlockAccount(ctx context.Context, token string) error
+	ChangePassword(ctx context.Context, user *model.User, session *model.Session, req model.ChangePasswordRequest, client model.ClientInfo) error
+	ReportDevice(ctx context.Context, req model.ReportDeviceRequest, client model.ClientInfo) error
 }
 
 type AuthServiceImpl struct {
-	UserRepo    repository.UserRepository
-	SessionRepo repository.SessionRepository
-	DB          *sql.DB
-	Validate    *validator.Validate
-	Cnf         *config.Config
-	RedisClient *redis.Client
-	Mailer      *config.Mailer
-	OauthClient *config.OauthClient
+	UserRepo         repository.UserRepository
+	SessionRepo      repository.SessionRepository
+	RefreshTokenRepo repository.RefreshTokenRepository
+	RecoveryCodeRepo repository.RecoveryCodeRepository
+	UserIdentityRepo repository.UserIdentityRepository
+	RoleRepo         repository.RoleRepository
+	KnownDeviceRepo  repository.KnownDeviceRepository
+	DB               *sql.DB
+	Validate         *validator.Validate
+	Cnf              *config.Config
+	Keyring          *config.Keyring
+	RedisClient      *redis.Client
+	Mailer           *config.Mailer
+	OauthClient      *config.OauthClient
+	LoginThrottle    *LoginThrottle
+	OtpStore         *OtpStore
+	PasswordPolicy   *PasswordPolicy
+	PasswordHasher   helpers.PasswordHasher
+	AuditLog         *AuditLog
+	SessionCache     *SessionCache
+	SessionLifetime  *SessionLifetime
+	OidcClients      map[string]*OidcClient
 }
 
 func NewAuthService(
 	userRepo repository.UserRepository,
 	sessionRepo repository.SessionRepository,
+	refreshTokenRepo repository.RefreshTokenRepository,
+	recoveryCodeRepo repository.RecoveryCodeRepository,
+	userIdentityRepo repository.UserIdentityRepository,
+	roleRepo repository.RoleRepository,
+	knownDeviceRepo repository.KnownDeviceRepository,
 	DB *sql.DB, validate *validator.Validate,
 	cnf *config.Config,
+	keyring *config.Keyring,
 	redisClient *redis.Client,
 	mailer *config.Mailer,
 	oauthClient *config.OauthClient,
+	loginThrottle *LoginThrottle,
+	otpStore *OtpStore,
+	passwordPolicy *PasswordPolicy,
+	passwordHasher helpers.PasswordHasher,
+	auditLog *AuditLog,
+	sessionCache *SessionCache,
+	sessionLifetime *SessionLifetime,
 ) *AuthServiceImpl {
-	return &AuthServiceImpl{UserRepo: userRepo, SessionRepo: sessionRepo, DB: DB, Validate: validate, Cnf: cnf, RedisClient: redisClient, Mailer: mailer, OauthClient: oauthClient}
+	return &AuthServiceImpl{UserRepo: userRepo, SessionRepo: sessionRepo, RefreshTokenRepo: refreshTokenRepo, RecoveryCodeRepo: recoveryCodeRepo, UserIdentityRepo: userIdentityRepo, RoleRepo: roleRepo, KnownDeviceRepo: knownDeviceRepo, DB: DB, Validate: validate, Cnf: cnf, Keyring: keyring, RedisClient: redisClient, Mailer: mailer, OauthClient: oauthClient, LoginThrottle: loginThrottle, OtpStore: otpStore, PasswordPolicy: passwordPolicy, PasswordHasher: passwordHasher, AuditLog: auditLog, SessionCache: sessionCache, SessionLifetime: sessionLifetime,
+		OidcClients: NewOidcClients(oauthClient, cnf)}
+}
+
+func (s AuthServiceImpl) checkPasswordPolicy(req any, password string, email string) error {
+	msgs, err := s.PasswordPolicy.Check(password, email)
+	if err != nil {
+		log.Println("error while check password policy", err)
+		return exceptions.NewInternalServerError()
+	}
+
+	if len(msgs) > 0 {
+		return exceptions.NewFieldValidationError(req, "password", msgs)
+	}
+
+	return nil
+}
+
+type oauthState struct {
+	Provider   string `json:"provider"`
+	Verifier   string `json:"verifier"`
+	Nonce      string `json:"nonce"`
+	LinkUserId int    `json:"link_user_id,omitempty"`
+}
+
+func oauthStateKey(state string) string {
+	return "oauth_state:" + helpers.HashToken(state)
+}
+
+type issuedTokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int
+	Session      *model.Session
+	// NewDevice is set when the session comes from a device the user has not
+	// signed in from before
+	NewDevice bool
+}
+
+func (s AuthServiceImpl) signAccessToken(session *model.Session) (string, error) {
+	signedToken, err := helpers.SignToken(s.Keyring, helpers.AccessTokenType, session.Token, session.AccessExpiresAt)
+	if err != nil {
+		log.Println("error while sign access token", err)
+		return "", exceptions.NewInternalServerError()
+	}
+
+	return signedToken, nil
+}
+
+func (s AuthServiceImpl) issueRefreshToken(ctx context.Context, tx *sql.Tx, sessionId int, expiresAt time.Time) (string, error) {
+	refreshToken, err := helpers.GenerateRandomToken(32)
+	if err != nil {
+		return "", exceptions.NewInternalServerError()
+	}
+
+	_, err = s.RefreshTokenRepo.Save(ctx, tx, &model.RefreshToken{
+		SessionId: sessionId,
+		Token:     helpers.HashToken(refreshToken),
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+func (s AuthServiceImpl) createSession(ctx context.Context, tx *sql.Tx, user *model.User, client model.ClientInfo) (*issuedTokens, error) {
+	accessTokenTTL := s.Cnf.Env.GetDuration("ACCESS_TOKEN_TTL")
+	refreshTokenTTL := s.Cnf.Env.GetDuration("REFRESH_TOKEN_TTL")
+
+	now := time.Now()
+	session := &model.Session{
+		UserId:      user.Id,
+		Token:       uuid.NewString(),
+		CreatedAt:   now,
+		LastSeenAt:  now,
+		IpAddress:   client.IpAddress,
+		UserAgent:   client.UserAgent,
+		DeviceLabel: helpers.DeviceLabel(client.UserAgent),
+	}
+	session.ExpiresAt = s.SessionLifetime.Cap(session, now.Add(refreshTokenTTL))
+	session.AccessExpiresAt = s.SessionLifetime.Cap(session, now.Add(accessTokenTTL))
+
+	session, err := s.SessionRepo.Save(ctx, tx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	signedToken, err := s.signAccessToken(session)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := s.issueRefreshToken(ctx, tx, session.Id, session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	newDevice, err := s.rememberDevice(ctx, tx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &issuedTokens{
+		AccessToken:  signedToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(session.AccessExpiresAt.Sub(now).Seconds()),
+		Session:      session,
+		NewDevice:    newDevice,
+	}, nil
+}
+
+// rehashPassword upgrades a hash made with another algorithm or outdated cost
+// parameters. It runs on sign in, the only time the plain password is at hand,
+// once the sign in transaction has ended so a request never holds two
+// connections, and it never fails the sign in.
+func (s AuthServiceImpl) rehashPassword(ctx context.Context, user *model.User, password string) {
+	if !s.PasswordHasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	hashedPassword, err := s.PasswordHasher.Hash(password)
+	if err != nil {
+		log.Println("error while rehash password", err)
+		return
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return
+	}
+
+	err = s.UserRepo.ReplacePasswordHash(ctx, tx, user.Id, user.Password, hashedPassword)
+	if err != nil {
+		_ = tx.Rollback()
+		return
+	}
+
+	_ = tx.Commit()
+
+	user.Password = hashedPassword
+}
+
+// rememberDevice records the device of the session and reports whether it is
+// new to the user. The first device of a user is never reported as new, there
+// is nothing to compare it with.
+func (s AuthServiceImpl) rememberDevice(ctx context.Context, tx *sql.Tx, session *model.Session) (bool, error) {
+	known, err := s.KnownDeviceRepo.CountByUserId(ctx, tx, session.UserId)
+	if err != nil {
+		return false, err
+	}
+
+	remembered, err := s.KnownDeviceRepo.Remember(ctx, tx, &model.KnownDevice{
+		UserId:      session.UserId,
+		Fingerprint: helpers.DeviceFingerprint(session.IpAddress, session.UserAgent),
+		IpAddress:   session.IpAddress,
+		UserAgent:   session.UserAgent,
+		DeviceLabel: session.DeviceLabel,
+		FirstSeenAt: session.CreatedAt,
+		LastSeenAt:  session.CreatedAt,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return remembered && known > 0, nil
+}
+
+// notifyNewDevice mails the user about a sign in from a new device, with a
+// link that signs the device out if it was not them.
+func (s AuthServiceImpl) notifyNewDevice(ctx context.Context, user *model.User, tokens *issuedTokens, client model.ClientInfo) {
+	if !tokens.NewDevice {
+		return
+	}
+
+	session := tokens.Session
+	s.AuditLog.Record(ctx, model.AuditNewDeviceLogin, model.AuditSuccess, user, "", client, fmt.Sprintf("session %d", session.Id))
+
+	ttl := s.Cnf.Env.GetDuration("NEW_DEVICE_REPORT_TTL")
+	token, err := helpers.SignToken(s.Keyring, helpers.ReportDeviceTokenType, strconv.Itoa(session.Id), time.Now().Add(ttl))
+	if err != nil {
+		log.Println("error while sign report device token", err)
+		return
+	}
+
+	newDeviceLoginData := config.NewDeviceLoginData{
+		Email:       user.Email,
+		DeviceLabel: session.DeviceLabel,
+		IpAddress:   session.IpAddress,
+		UserAgent:   session.UserAgent,
+		SignedInAt:  session.CreatedAt.UTC().Format("2 January 2006 15:04 MST"),
+		ReportUrl:   s.Cnf.Env.GetString("NEW_DEVICE_REPORT_URL") + "?token=" + url.QueryEscape(token),
+		ExpiresIn:   humanizeDuration(ttl),
+	}
+
+	err = s.sendTemplateEmail(user.Email, "New Sign In To Your Account", NewDeviceLoginTemplateEmail, newDeviceLoginData)
+	if err != nil {
+		log.Println("error while send new device login email", err)
+	}
 }
 
-func (s AuthServiceImpl) Register(ctx context.Context, req model.RegisterRequest) (*model.RegisterResponse, error) {
+func (s AuthServiceImpl) Register(ctx context.Context, req model.RegisterRequest, client model.ClientInfo) (*model.RegisterResponse, error) {
 	err := s.Validate.Struct(&req)
 	if err != nil {
 		return nil, exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
 	}
 
+	err = s.checkPasswordPolicy(req, req.Password, req.Email)
+	if err != nil {
+		return nil, err
+	}
+
 	tx, err := s.DB.Begin()
 	if err != nil {
 		return nil, exceptions.NewInternalServerError()
@@ -83,7 +350,7 @@ func (s AuthServiceImpl) Register(ctx context.Context, req model.RegisterRequest
 		return nil, exceptions.NewBadRequestError("Email already registered")
 	}
 
-	hashedPassword, err := helpers.HashPassword(req.Password)
+	hashedPassword, err := s.PasswordHasher.Hash(req.Password)
 	if err != nil {
 		return nil, exceptions.NewInternalServerError()
 	}
@@ -100,38 +367,42 @@ func (s AuthServiceImpl) Register(ctx context.Context, req model.RegisterRequest
 		return nil, err
 	}
 
-	token := uuid.NewString()
-	encodedToken := helpers.StringToBase64([]byte(token))
-	encryptedToken, err := helpers.Encrypt(encodedToken, s.Cnf.Env.GetString("APP_KEY"))
-	if err != nil {
-		return nil, exceptions.NewInternalServerError()
-	}
-
-	_, err = s.SessionRepo.Save(ctx, tx, &model.Session{
-		UserId:    user.Id,
-		Token:     token,
-		ExpiresAt: time.Now().Add(time.Hour * 24 * 7),
-	})
+	tokens, err := s.createSession(ctx, tx, user, client)
 	if err != nil {
 		_ = tx.Rollback()
-		return nil, exceptions.NewInternalServerError()
+		return nil, err
 	}
 
 	_ = tx.Commit()
 
+	s.AuditLog.Record(ctx, model.AuditRegister, model.AuditSuccess, user, "", client, "")
+
+	err = s.sendVerificationEmail(user)
+	if err != nil {
+		log.Println("error while send verification email", err)
+	}
+
 	return &model.RegisterResponse{
-		Id:    user.Id,
-		Email: user.Email,
-		Token: encryptedToken,
+		Id:           user.Id,
+		Email:        user.Email,
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
 	}, nil
 }
 
-func (s AuthServiceImpl) Login(ctx context.Context, req model.LoginRequest) (*model.LoginResponse, error) {
+func (s AuthServiceImpl) Login(ctx context.Context, req model.LoginRequest, client model.ClientInfo) (*model.LoginResponse, error) {
 	err := s.Validate.Struct(&req)
 	if err != nil {
 		return nil, exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
 	}
 
+	err = s.LoginThrottle.Check(ctx, req.Email, client.IpAddress)
+	if err != nil {
+		s.AuditLog.Record(ctx, model.AuditLogin, model.AuditFailure, nil, req.Email, client, "throttled")
+		return nil, err
+	}
+
 	tx, err := s.DB.Begin()
 	if err != nil {
 		return nil, exceptions.NewInternalServerError()
@@ -139,224 +410,328 @@ func (s AuthServiceImpl) Login(ctx context.Context, req model.LoginRequest) (*mo
 
 	user, err := s.UserRepo.FindByEmail(ctx, tx, req.Email)
 	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
-		return nil, exceptions.NewHttpConflictError("Invalid Credentials")
+		_ = tx.Rollback()
+		return nil, s.loginFailed(ctx, req.Email, client, nil)
 	} else if err != nil && !errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
 		return nil, err
 	}
 
-	if !helpers.VerifyPassword(req.Password, user.Password) {
-		return nil, exceptions.NewHttpConflictError("Invalid Credentials")
+	if !s.PasswordHasher.Verify(req.Password, user.Password) {
+		_ = tx.Rollback()
+		return nil, s.loginFailed(ctx, req.Email, client, user)
+	}
+
+	if user.PasswordResetRequiredAt != nil {
+		_ = tx.Rollback()
+		s.AuditLog.Record(ctx, model.AuditLogin, model.AuditFailure, user, "", client, "password reset required")
+		return nil, exceptions.NewForbiddenError("Your password must be reset before you can sign in with it")
+	}
+
+	// the failures are only forgotten once the second factor is passed too,
+	// otherwise signing in again would hand out fresh guesses at the code
+	if user.TotpEnabledAt != nil {
+		_ = tx.Rollback()
+		s.rehashPassword(ctx, user, req.Password)
+		s.AuditLog.Record(ctx, model.AuditLogin, model.AuditSuccess, user, "", client, "two-factor required")
+		return s.twoFactorChallenge(user)
 	}
 
-	token := uuid.NewString()
-	encodedToken := helpers.StringToBase64([]byte(token))
-	encryptedToken, err := helpers.Encrypt(encodedToken, s.Cnf.Env.GetString("APP_KEY"))
+	err = s.LoginThrottle.Reset(ctx, req.Email)
 	if err != nil {
-		return nil, exceptions.NewInternalServerError()
+		_ = tx.Rollback()
+		return nil, err
 	}
 
-	_, err = s.SessionRepo.Save(ctx, tx, &model.Session{
-		UserId:    user.Id,
-		Token:     token,
-		ExpiresAt: time.Now().Add(time.Hour * 24 * 7),
-	})
+	tokens, err := s.createSession(ctx, tx, user, client)
 	if err != nil {
 		_ = tx.Rollback()
-		return nil, exceptions.NewInternalServerError()
+		return nil, err
 	}
 
 	_ = tx.Commit()
 
+	s.rehashPassword(ctx, user, req.Password)
+
+	s.AuditLog.Record(ctx, model.AuditLogin, model.AuditSuccess, user, "", client, "")
+	s.notifyNewDevice(ctx, user, tokens, client)
+
 	return &model.LoginResponse{
-		Id:    user.Id,
-		Email: user.Email,
-		Token: encryptedToken,
+		Id:           user.Id,
+		Email:        user.Email,
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
 	}, nil
 }
 
-func (s AuthServiceImpl) Me(ctx context.Context, token string) (*model.MeResponse, error) {
-	decodedToken, err := helpers.Decrypt(token, s.Cnf.Env.GetString("APP_KEY"))
+// loginFailed records the failed attempt and returns the error for the
+// client. When the failure locks an existing account an unlock link is mailed.
+func (s AuthServiceImpl) loginFailed(ctx context.Context, email string, client model.ClientInfo, user *model.User) error {
+	detail := "wrong password"
+	if user == nil {
+		detail = "unknown email"
+	}
+	s.AuditLog.Record(ctx, model.AuditLogin, model.AuditFailure, user, email, client, detail)
+
+	err := s.recordLoginFailure(ctx, email, client, user)
 	if err != nil {
-		return nil, exceptions.NewUnauthorizedError("Unauthorized")
+		return err
 	}
 
-	decodedTokenBase64 := helpers.Base64ToString(decodedToken)
+	return exceptions.NewHttpConflictError("Invalid Credentials")
+}
 
-	tx, err := s.DB.Begin()
+// recordLoginFailure counts a failed password or second factor against the
+// account and IP. When the failure locks an existing account an unlock link
+// is mailed.
+func (s AuthServiceImpl) recordLoginFailure(ctx context.Context, email string, client model.ClientInfo, user *model.User) error {
+	locked, err := s.LoginThrottle.RecordFailure(ctx, email, client.IpAddress)
 	if err != nil {
-		return nil, exceptions.NewInternalServerError()
+		return err
 	}
 
-	session, err := s.SessionRepo.FindByToken(ctx, tx, decodedTokenBase64)
-	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
-		return nil, exceptions.NewUnauthorizedError("Unauthorized")
-	} else if err != nil {
-		return nil, err
+	if locked && user != nil {
+		err = s.sendUnlockAccountEmail(user)
+		if err != nil {
+			log.Println("error while send unlock account email", err)
+		}
 	}
 
-	if session.ExpiresAt.Before(time.Now()) {
-		return nil, exceptions.NewUnauthorizedError("Unauthorized")
-	}
+	return nil
+}
 
-	user, err := s.UserRepo.FindById(ctx, tx, session.UserId)
+func (s AuthServiceImpl) twoFactorChallenge(user *model.User) (*model.LoginResponse, error) {
+	challengeToken, err := helpers.SignToken(s.Keyring, helpers.TwoFactorChallengeTokenType, strconv.Itoa(user.Id), time.Now().Add(time.Minute*5))
 	if err != nil {
-		return nil, err
+		log.Println("error while sign two-factor challenge token", err)
+		return nil, exceptions.NewInternalServerError()
 	}
 
-	_ = tx.Commit()
-
-	return &model.MeResponse{
-		Id:    user.Id,
-		Email: user.Email,
+	return &model.LoginResponse{
+		Id:                user.Id,
+		Email:             user.Email,
+		TwoFactorRequired: true,
+		ChallengeToken:    challengeToken,
 	}, nil
 }
 
-func (s AuthServiceImpl) ForgetPassword(ctx context.Context, req model.ForgetPasswordRequest) error {
+func (s AuthServiceImpl) LoginTwoFactor(ctx context.Context, req model.LoginTwoFactorRequest, client model.ClientInfo) (*model.LoginResponse, error) {
 	err := s.Validate.Struct(req)
 	if err != nil {
-		return exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+		return nil, exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+	}
+
+	claims, err := helpers.VerifyToken(s.Keyring, helpers.TwoFactorChallengeTokenType, req.ChallengeToken)
+	if err != nil {
+		return nil, exceptions.NewUnauthorizedError("Invalid or expired challenge")
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, exceptions.NewUnauthorizedError("Invalid or expired challenge")
+	}
+
+	challengeKey := fmt.Sprintf("two_factor_challenge:%s", helpers.HashToken(req.ChallengeToken))
+	attempts, err := s.RedisClient.Incr(ctx, challengeKey).Result()
+	if err != nil {
+		log.Println("error while increment two-factor attempts", err)
+		return nil, exceptions.NewInternalServerError()
+	}
+	if attempts == 1 {
+		_ = s.RedisClient.Expire(ctx, challengeKey, time.Minute*5).Err()
+	}
+	if attempts > twoFactorMaxAttempts {
+		return nil, exceptions.NewUnauthorizedError("Invalid or expired challenge")
 	}
 
 	tx, err := s.DB.Begin()
 	if err != nil {
-		return exceptions.NewInternalServerError()
+		return nil, exceptions.NewInternalServerError()
 	}
 
-	_, err = s.UserRepo.FindByEmail(ctx, tx, req.Email)
+	user, err := s.UserRepo.FindById(ctx, tx, userId)
 	if err != nil {
-		time.Sleep(3 * time.Second)
-		return nil
+		_ = tx.Rollback()
+		return nil, exceptions.NewUnauthorizedError("Invalid or expired challenge")
 	}
 
-	otpCode := helpers.GenerateRandomCodeForOtp()
-	key := fmt.Sprintf("otp:%s", req.Email)
-	err = s.RedisClient.SetEx(ctx, key, otpCode, time.Minute*5).Err()
+	if user.TotpEnabledAt == nil {
+		_ = tx.Rollback()
+		return nil, exceptions.NewUnauthorizedError("Invalid or expired challenge")
+	}
+
+	// codes are guessed against the same account limit as passwords, so a
+	// new challenge does not start the count over
+	err = s.LoginThrottle.Check(ctx, user.Email, client.IpAddress)
 	if err != nil {
-		log.Println("error while set otp to redis", err)
-		return exceptions.NewInternalServerError()
+		_ = tx.Rollback()
+		s.AuditLog.Record(ctx, model.AuditLoginTwoFactor, model.AuditFailure, user, "", client, "throttled")
+		return nil, err
 	}
 
-	tmpl, err := template.New("email").Parse(OTPTemplateEmail)
+	ok, err := verifySecondFactor(ctx, tx, s.RedisClient, s.Keyring, s.RecoveryCodeRepo, user, req.Code)
 	if err != nil {
-		log.Println("error while parse template", err)
-		return exceptions.NewInternalServerError()
+		_ = tx.Rollback()
+		return nil, err
 	}
 
-	otpData := config.SendOtpEmailData{
-		OtpCode: otpCode,
-		Email:   req.Email,
+	if !ok {
+		_ = tx.Rollback()
+		s.AuditLog.Record(ctx, model.AuditLoginTwoFactor, model.AuditFailure, user, "", client, "invalid code")
+
+		err = s.recordLoginFailure(ctx, user.Email, client, user)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, exceptions.NewUnauthorizedError("Invalid two-factor code")
 	}
 
-	var body bytes.Buffer
-	if err := tmpl.Execute(&body, otpData); err != nil {
-		log.Println("error while execute template", err)
-		return exceptions.NewInternalServerError()
+	err = s.LoginThrottle.Reset(ctx, user.Email)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
 	}
 
-	err = s.Mailer.SendEmail(req.Email, "Forget Password OTP", body.String())
+	tokens, err := s.createSession(ctx, tx, user, client)
 	if err != nil {
-		log.Println("error while send email", err)
-		return exceptions.NewInternalServerError()
+		_ = tx.Rollback()
+		return nil, err
 	}
 
-	return nil
+	_ = tx.Commit()
+
+	s.AuditLog.Record(ctx, model.AuditLoginTwoFactor, model.AuditSuccess, user, "", client, "")
+	s.notifyNewDevice(ctx, user, tokens, client)
+
+	// a challenge can only complete a single login
+	_ = s.RedisClient.Set(ctx, challengeKey, twoFactorMaxAttempts+1, time.Minute*5).Err()
+
+	return &model.LoginResponse{
+		Id:           user.Id,
+		Email:        user.Email,
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	}, nil
 }
 
-func (s AuthServiceImpl) VerifyForgetPasswordOtp(ctx context.Context, req model.VerifyForgetPasswordOtpRequest) (*model.VerifyForgetPasswordOtpResponse, error) {
+// SendMagicLink mails a single use sign in link. It answers the same way
+// whether or not the email belongs to an account, and everything past
+// validation runs in the background so the response time does not tell
+// either. Failures there are only logged.
+func (s AuthServiceImpl) SendMagicLink(ctx context.Context, req model.MagicLinkRequest) error {
 	err := s.Validate.Struct(req)
 	if err != nil {
-		return nil, exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+		return exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
 	}
 
-	key := fmt.Sprintf("otp:%s", req.Email)
-	otpCode, err := s.RedisClient.Get(ctx, key).Result()
+	// the request context is done once the response is sent
+	go func() {
+		err := s.sendMagicLink(context.Background(), req.Email)
+		if err != nil {
+			log.Println("error while send magic link", err)
+		}
+	}()
+
+	return nil
+}
+
+func (s AuthServiceImpl) sendMagicLink(ctx context.Context, email string) error {
+	tx, err := s.DB.Begin()
 	if err != nil {
-		return nil, exceptions.NewBadRequestError("Invalid OTP")
+		return err
+	}
+
+	user, err := s.UserRepo.FindByEmail(ctx, tx, email)
+	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
+		return nil
+	} else if err != nil {
+		_ = tx.Rollback()
+		return err
 	}
 
-	if otpCode != req.Otp {
-		return nil, exceptions.NewBadRequestError("Invalid OTP")
+	_ = tx.Commit()
+
+	// the ip based rate limiter does not stop one inbox being flooded from
+	// many addresses
+	fresh, err := s.RedisClient.SetNX(ctx, "magic_link_sent:"+strings.ToLower(user.Email), 1, time.Minute).Result()
+	if err != nil || !fresh {
+		return err
 	}
 
-	err = s.RedisClient.Del(ctx, key).Err()
+	nonce, err := helpers.GenerateRandomToken(32)
 	if err != nil {
-		log.Println("error while delete otp from redis", err)
-		return nil, exceptions.NewInternalServerError()
+		return err
 	}
 
-	resetPasswordKey := fmt.Sprintf("reset-password:%s", req.Email)
-	resetPasswordToken := uuid.NewString()
-	encodedToken := helpers.StringToBase64([]byte(resetPasswordToken))
-	encryptedToken, err := helpers.Encrypt(encodedToken, s.Cnf.Env.GetString("APP_KEY"))
+	ttl := s.Cnf.Env.GetDuration("MAGIC_LINK_TTL")
+	token, err := helpers.SignToken(s.Keyring, helpers.MagicLinkTokenType, nonce, time.Now().Add(ttl))
 	if err != nil {
-		log.Println("error while encrypt token", err)
-		return nil, exceptions.NewInternalServerError()
+		return err
 	}
 
-	err = s.RedisClient.SetEx(ctx, resetPasswordKey, resetPasswordToken, time.Minute*5).Err()
+	err = s.RedisClient.Set(ctx, magicLinkKey(nonce), fmt.Sprintf("%d:%s", user.Id, user.Email), ttl).Err()
 	if err != nil {
-		log.Println("error while set reset password token to redis", err)
-		return nil, exceptions.NewInternalServerError()
+		return err
 	}
 
-	verifyForgetPasswordOtpResponse := model.VerifyForgetPasswordOtpResponse{
-		Email:              req.Email,
-		ResetPasswordToken: encryptedToken,
+	magicLinkData := config.MagicLinkData{
+		Email:     user.Email,
+		LoginUrl:  s.Cnf.Env.GetString("MAGIC_LINK_URL") + "?token=" + url.QueryEscape(token),
+		ExpiresIn: humanizeDuration(ttl),
 	}
 
-	return &verifyForgetPasswordOtpResponse, nil
+	return s.sendTemplateEmail(user.Email, "Your Sign In Link", MagicLinkTemplateEmail, magicLinkData)
 }
 
-func (s AuthServiceImpl) ResetPassword(ctx context.Context, req model.ResetPasswordRequest) (*model.ResetPasswordResponse, error) {
+// MagicLinkLogin consumes a link sent by SendMagicLink and signs the user in
+// like Login does, including its lockout and required password reset.
+// Following the link proves control of the inbox, so the email is marked
+// verified as well.
+func (s AuthServiceImpl) MagicLinkLogin(ctx context.Context, req model.MagicLinkLoginRequest, client model.ClientInfo) (*model.LoginResponse, error) {
 	err := s.Validate.Struct(req)
 	if err != nil {
 		return nil, exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
 	}
 
-	hashPassword, err := helpers.HashPassword(req.Password)
+	claims, err := helpers.VerifyToken(s.Keyring, helpers.MagicLinkTokenType, req.Token)
 	if err != nil {
-		return nil, exceptions.NewInternalServerError()
+		return nil, exceptions.NewUnauthorizedError("Invalid or expired sign in link")
 	}
 
-	tx, err := s.DB.Begin()
-	if err != nil {
+	owner, err := s.RedisClient.Get(ctx, magicLinkKey(claims.Subject)).Result()
+	if err != nil && errors.Is(err, redis.Nil) {
+		return nil, exceptions.NewUnauthorizedError("Invalid or expired sign in link")
+	} else if err != nil {
+		log.Println("error while get magic link from redis", err)
 		return nil, exceptions.NewInternalServerError()
 	}
-	_, err = s.UserRepo.UpdatePassword(ctx, tx, req.Email, hashPassword)
-	if err != nil {
-		return nil, err
-	}
-
-	_ = tx.Commit()
-
-	resetPasswordResponse := model.ResetPasswordResponse{
-		Message: "Success Reset Password",
-	}
-	return &resetPasswordResponse, nil
-}
 
-func (s AuthServiceImpl) GoogleCallback(ctx context.Context, req model.GoogleCallbackRequest) (*model.LoginResponse, error) {
-	err := s.Validate.Struct(req)
+	rawUserId, email, _ := strings.Cut(owner, ":")
+	userId, err := strconv.Atoi(rawUserId)
 	if err != nil {
-		return nil, exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+		return nil, exceptions.NewUnauthorizedError("Invalid or expired sign in link")
 	}
 
-	url := fmt.Sprintf("%s?access_token=%s", config.GoogleUserInfoEndpoint, req.Token)
-	http.DefaultClient.Timeout = 2 * time.Second
-	response, err := http.Get(url)
+	// a locked account keeps its link for when the lock is over
+	err = s.LoginThrottle.Check(ctx, email, client.IpAddress)
 	if err != nil {
-		return nil, exceptions.NewBadRequestError("Invalid google access token")
+		s.AuditLog.Record(ctx, model.AuditMagicLinkLogin, model.AuditFailure, nil, email, client, "throttled")
+		return nil, err
 	}
-	defer response.Body.Close()
 
-	var googleUserInfo model.GoogleUserInfo
-	if err := json.NewDecoder(response.Body).Decode(&googleUserInfo); err != nil {
-		log.Println("error while decode google user info", err)
+	deleted, err := s.RedisClient.Del(ctx, magicLinkKey(claims.Subject)).Result()
+	if err != nil {
+		log.Println("error while delete magic link from redis", err)
 		return nil, exceptions.NewInternalServerError()
 	}
 
-	if googleUserInfo.Email == "" || googleUserInfo.Id == "" {
-		return nil, exceptions.NewBadRequestError("Invalid google access token")
+	// another request consumed the link in the meantime
+	if deleted == 0 {
+		return nil, exceptions.NewUnauthorizedError("Invalid or expired sign in link")
 	}
 
 	tx, err := s.DB.Begin()
@@ -364,48 +739,1180 @@ func (s AuthServiceImpl) GoogleCallback(ctx context.Context, req model.GoogleCal
 		return nil, exceptions.NewInternalServerError()
 	}
 
-	user, err := s.UserRepo.FindByEmail(ctx, tx, googleUserInfo.Email)
-	if err != nil && !errors.Is(err, exceptions.NotFoundError{}) {
-		return nil, err
-	}
+	user, err := s.UserRepo.FindById(ctx, tx, userId)
+	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
+		return nil, exceptions.NewUnauthorizedError("Invalid or expired sign in link")
+	} else if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	// the link was sent to the address the account had at the time
+	if user.Email != email {
+		_ = tx.Rollback()
+		return nil, exceptions.NewUnauthorizedError("Invalid or expired sign in link")
+	}
+
+	if user.PasswordResetRequiredAt != nil {
+		_ = tx.Rollback()
+		s.AuditLog.Record(ctx, model.AuditMagicLinkLogin, model.AuditFailure, user, "", client, "password reset required")
+		return nil, exceptions.NewForbiddenError("Your password must be reset before you can sign in")
+	}
+
+	verifiedNow := user.EmailVerifiedAt == nil
+	if verifiedNow {
+		now := time.Now()
+		err = s.UserRepo.MarkEmailVerified(ctx, tx, user.Id, now)
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+		user.EmailVerifiedAt = &now
+	}
+
+	if user.TotpEnabledAt != nil {
+		_ = tx.Commit()
+		if verifiedNow {
+			_ = s.SessionCache.Forget(ctx, user.Id)
+		}
+		s.AuditLog.Record(ctx, model.AuditMagicLinkLogin, model.AuditSuccess, user, "", client, "two-factor required")
+		return s.twoFactorChallenge(user)
+	}
+
+	tokens, err := s.createSession(ctx, tx, user, client)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	if verifiedNow {
+		_ = s.SessionCache.Forget(ctx, user.Id)
+	}
+	s.AuditLog.Record(ctx, model.AuditMagicLinkLogin, model.AuditSuccess, user, "", client, "")
+	s.notifyNewDevice(ctx, user, tokens, client)
+
+	return &model.LoginResponse{
+		Id:           user.Id,
+		Email:        user.Email,
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	}, nil
+}
+
+func magicLinkKey(nonce string) string {
+	return "magic_link:" + helpers.HashToken(nonce)
+}
+
+func (s AuthServiceImpl) Me(ctx context.Context, token string) (*model.MeResponse, error) {
+	claims, err := helpers.VerifyToken(s.Keyring, helpers.AccessTokenType, token)
+	if err != nil {
+		return nil, exceptions.NewUnauthorizedError("Unauthorized")
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	session, err := s.SessionRepo.FindByToken(ctx, tx, claims.Subject)
+	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
+		return nil, exceptions.NewUnauthorizedError("Unauthorized")
+	} else if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	now := time.Now()
+	if session.AccessExpiresAt.Before(now) || !s.SessionLifetime.Active(session, now) {
+		_ = tx.Rollback()
+		return nil, exceptions.NewUnauthorizedError("Unauthorized")
+	}
+
+	user, err := s.UserRepo.FindById(ctx, tx, session.UserId)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	roles, err := s.RoleRepo.FindRoleNamesByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	meResponse := model.MeResponse{
+		Id:               user.Id,
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerifiedAt != nil,
+		TwoFactorEnabled: user.TotpEnabledAt != nil,
+		Roles:            append([]string{model.RoleUser}, roles...),
+	}
+
+	if session.ImpersonatorId != nil {
+		meResponse.Impersonation = &model.ImpersonationResponse{
+			ImpersonatorId: *session.ImpersonatorId,
+			ReadOnly:       session.ImpersonationReadOnly,
+			ExpiresAt:      session.AccessExpiresAt,
+		}
+	}
+
+	return &meResponse, nil
+}
+
+func (s AuthServiceImpl) ForgetPassword(ctx context.Context, req model.ForgetPasswordRequest, client model.ClientInfo) error {
+	err := s.Validate.Struct(req)
+	if err != nil {
+		return exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	user, err := s.UserRepo.FindByEmail(ctx, tx, req.Email)
+	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
+		s.AuditLog.Record(ctx, model.AuditOtpRequested, model.AuditFailure, nil, req.Email, client, "unknown email")
+		time.Sleep(3 * time.Second)
+		return nil
+	} else if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_ = tx.Commit()
+
+	otpCode, err := s.OtpStore.Issue(ctx, ForgetPasswordOtpPurpose, req.Email)
+	if err != nil {
+		return err
+	}
+
+	otpData := config.SendOtpEmailData{
+		OtpCode:   otpCode,
+		Email:     req.Email,
+		ExpiresIn: humanizeDuration(s.Cnf.Env.GetDuration("OTP_TTL")),
+	}
+
+	err = s.sendTemplateEmail(req.Email, "Forget Password OTP", OTPTemplateEmail, otpData)
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	s.AuditLog.Record(ctx, model.AuditOtpRequested, model.AuditSuccess, user, "", client, "")
+
+	return nil
+}
+
+func (s AuthServiceImpl) VerifyForgetPasswordOtp(ctx context.Context, req model.VerifyForgetPasswordOtpRequest, client model.ClientInfo) (*model.VerifyForgetPasswordOtpResponse, error) {
+	err := s.Validate.Struct(req)
+	if err != nil {
+		return nil, exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+	}
+
+	err = s.OtpStore.Verify(ctx, ForgetPasswordOtpPurpose, req.Email, req.Otp)
+	if err != nil {
+		s.AuditLog.Record(ctx, model.AuditOtpVerified, model.AuditFailure, nil, req.Email, client, "")
+		return nil, err
+	}
+
+	resetPasswordKey := fmt.Sprintf("reset-password:%s", req.Email)
+	resetPasswordToken := uuid.NewString()
+	signedToken, err := helpers.SignToken(s.Keyring, helpers.ResetPasswordTokenType, resetPasswordToken, time.Now().Add(time.Minute*5))
+	if err != nil {
+		log.Println("error while sign token", err)
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	err = s.RedisClient.SetEx(ctx, resetPasswordKey, resetPasswordToken, time.Minute*5).Err()
+	if err != nil {
+		log.Println("error while set reset password token to redis", err)
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	s.AuditLog.Record(ctx, model.AuditOtpVerified, model.AuditSuccess, nil, req.Email, client, "")
+
+	verifyForgetPasswordOtpResponse := model.VerifyForgetPasswordOtpResponse{
+		Email:              req.Email,
+		ResetPasswordToken: signedToken,
+	}
+
+	return &verifyForgetPasswordOtpResponse, nil
+}
+
+func (s AuthServiceImpl) ResetPassword(ctx context.Context, req model.ResetPasswordRequest, client model.ClientInfo) (*model.ResetPasswordResponse, error) {
+	err := s.Validate.Struct(req)
+	if err != nil {
+		return nil, exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+	}
+
+	// checked before the token is consumed so a rejected password can be retried
+	err = s.checkPasswordPolicy(req, req.Password, req.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := helpers.VerifyToken(s.Keyring, helpers.ResetPasswordTokenType, req.ResetPasswordToken)
+	if err != nil {
+		return nil, exceptions.NewBadRequestError("Invalid or expired reset password token")
+	}
+
+	// the token is bound to the email it was issued for and can only be used once
+	resetPasswordKey := fmt.Sprintf("reset-password:%s", req.Email)
+	storedToken, err := s.RedisClient.GetDel(ctx, resetPasswordKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Println("error while get reset password token from redis", err)
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	if storedToken == "" || !hmac.Equal([]byte(storedToken), []byte(claims.Subject)) {
+		s.AuditLog.Record(ctx, model.AuditPasswordReset, model.AuditFailure, nil, req.Email, client, "invalid token")
+		return nil, exceptions.NewBadRequestError("Invalid or expired reset password token")
+	}
+
+	hashPassword, err := s.PasswordHasher.Hash(req.Password)
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+	user, err := s.UserRepo.UpdatePassword(ctx, tx, req.Email, hashPassword)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	err = s.SessionRepo.RevokeAllByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	s.AuditLog.Record(ctx, model.AuditPasswordReset, model.AuditSuccess, user, "", client, "")
+	err = s.SessionCache.Forget(ctx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.LoginThrottle.Reset(ctx, user.Email)
+	if err != nil {
+		log.Println("error while reset login throttle", err)
+	}
+
+	passwordChangedData := config.PasswordChangedEmailData{
+		Name: user.Email,
+	}
+
+	err = s.sendTemplateEmail(user.Email, "Your Password Was Changed", ResetPasswordTemplateEmail, passwordChangedData)
+	if err != nil {
+		log.Println("error while send password changed email", err)
+	}
+
+	resetPasswordResponse := model.ResetPasswordResponse{
+		Message: "Success Reset Password",
+	}
+	return &resetPasswordResponse, nil
+}
+
+func (s AuthServiceImpl) ChangePassword(ctx context.Context, user *model.User, session *model.Session, req model.ChangePasswordRequest, client model.ClientInfo) error {
+	err := s.Validate.Struct(req)
+	if err != nil {
+		return exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+	}
+
+	err = s.checkPasswordPolicy(req, req.Password, user.Email)
+	if err != nil {
+		return err
+	}
+
+	hashPassword, err := s.PasswordHasher.Hash(req.Password)
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	// the user from the request context carries no credentials
+	user, err = s.UserRepo.FindById(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	// users created through a social login have no password yet and may set a first one
+	if user.Password != "" && !s.PasswordHasher.Verify(req.CurrentPassword, user.Password) {
+		_ = tx.Rollback()
+		s.AuditLog.Record(ctx, model.AuditPasswordChanged, model.AuditFailure, user, "", client, "wrong current password")
+		return exceptions.NewBadRequestError("Current password is incorrect")
+	}
+
+	_, err = s.UserRepo.UpdatePassword(ctx, tx, user.Email, hashPassword)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if req.RevokeOtherSessions {
+		err = s.SessionRepo.RevokeAllByUserIdExcept(ctx, tx, user.Id, session.Id)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	_ = tx.Commit()
+
+	detail := ""
+	if req.RevokeOtherSessions {
+		detail = "other sessions revoked"
+	}
+	s.AuditLog.Record(ctx, model.AuditPasswordChanged, model.AuditSuccess, user, "", client, detail)
+	err = s.SessionCache.Forget(ctx, user.Id)
+	if err != nil {
+		return err
+	}
+
+	passwordChangedData := config.PasswordChangedEmailData{
+		Name: user.Email,
+	}
+
+	err = s.sendTemplateEmail(user.Email, "Your Password Was Changed", ResetPasswordTemplateEmail, passwordChangedData)
+	if err != nil {
+		log.Println("error while send password changed email", err)
+	}
+
+	return nil
+}
+
+// OauthLogin starts the authorization code flow with the provider. The
+// returned state must be bound to the browser (e.g. a cookie) and presented
+// again on the callback.
+func (s AuthServiceImpl) OauthLogin(ctx context.Context, provider string) (string, string, error) {
+	return s.startOauthFlow(ctx, provider, 0)
+}
+
+// LinkIdentity starts the same flow as OauthLogin, but the callback attaches
+// the provider account to the given user instead of signing in.
+func (s AuthServiceImpl) LinkIdentity(ctx context.Context, user *model.User, provider string) (string, string, error) {
+	return s.startOauthFlow(ctx, provider, user.Id)
+}
+
+func (s AuthServiceImpl) oidcClient(ctx context.Context, provider string) (*OidcClient, *oauth2.Config, *helpers.JwksCache, error) {
+	oidcClient, ok := s.OidcClients[strings.ToLower(provider)]
+	if !ok {
+		return nil, nil, nil, exceptions.NewHttpNotFoundError("Provider not found")
+	}
+
+	oauthConfig, jwks, err := oidcClient.resolve(ctx)
+	if err != nil {
+		log.Printf("error while resolve %s endpoints: %v", oidcClient.Provider.Name, err)
+		return nil, nil, nil, exceptions.NewInternalServerError()
+	}
+
+	return oidcClient, oauthConfig, jwks, nil
+}
+
+func (s AuthServiceImpl) startOauthFlow(ctx context.Context, provider string, linkUserId int) (string, string, error) {
+	oidcClient, oauthConfig, _, err := s.oidcClient(ctx, provider)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := helpers.GenerateRandomToken(32)
+	if err != nil {
+		return "", "", exceptions.NewInternalServerError()
+	}
+
+	nonce, err := helpers.GenerateRandomToken(32)
+	if err != nil {
+		return "", "", exceptions.NewInternalServerError()
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	pending, _ := json.Marshal(oauthState{Provider: oidcClient.Provider.Name, Verifier: verifier, Nonce: nonce, LinkUserId: linkUserId})
+	err = s.RedisClient.Set(ctx, oauthStateKey(state), pending, s.Cnf.Env.GetDuration("OAUTH_STATE_TTL")).Err()
+	if err != nil {
+		log.Println("error while set oauth state to redis", err)
+		return "", "", exceptions.NewInternalServerError()
+	}
+
+	authUrl := oauthConfig.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oauth2.SetAuthURLParam("nonce", nonce))
+
+	return authUrl, state, nil
+}
+
+// OauthCallback finishes a flow started by OauthLogin or LinkIdentity. It
+// returns a login response for the former and the linked identity for the
+// latter.
+func (s AuthServiceImpl) OauthCallback(ctx context.Context, provider string, req model.OauthCallbackRequest, client model.ClientInfo) (*model.LoginResponse, *model.IdentityResponse, error) {
+	err := s.Validate.Struct(req)
+	if err != nil {
+		return nil, nil, exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+	}
+
+	oidcClient, oauthConfig, jwks, err := s.oidcClient(ctx, provider)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !hmac.Equal([]byte(req.State), []byte(req.BoundState)) {
+		return nil, nil, exceptions.NewBadRequestError("Invalid oauth state")
+	}
+
+	// the state is single use so a leaked callback url cannot be replayed
+	rawPending, err := s.RedisClient.GetDel(ctx, oauthStateKey(req.State)).Bytes()
+	if err != nil && errors.Is(err, redis.Nil) {
+		return nil, nil, exceptions.NewBadRequestError("Invalid oauth state")
+	} else if err != nil {
+		log.Println("error while get oauth state from redis", err)
+		return nil, nil, exceptions.NewInternalServerError()
+	}
+
+	var pending oauthState
+	if err := json.Unmarshal(rawPending, &pending); err != nil {
+		return nil, nil, exceptions.NewInternalServerError()
+	}
+
+	name := oidcClient.Provider.Name
+	if pending.Provider != name {
+		return nil, nil, exceptions.NewBadRequestError("Invalid oauth state")
+	}
+
+	exchangeCtx := context.WithValue(ctx, oauth2.HTTPClient, s.OauthClient.HttpClient)
+	token, err := oauthConfig.Exchange(exchangeCtx, req.Code, oauth2.VerifierOption(pending.Verifier))
+	if err != nil {
+		log.Printf("error while exchange %s code: %v", name, err)
+		return nil, nil, exceptions.NewBadRequestError("Invalid authorization code")
+	}
+
+	rawIdToken, _ := token.Extra("id_token").(string)
+	claims, err := helpers.VerifyIdToken(ctx, jwks, rawIdToken, oidcClient.Provider.ClientId, oidcClient.Provider.Issuers)
+	if err != nil {
+		log.Printf("error while verify %s id token: %v", name, err)
+		return nil, nil, exceptions.NewUnauthorizedError("Invalid id token")
+	}
+
+	if !hmac.Equal([]byte(claims.Nonce), []byte(pending.Nonce)) {
+		return nil, nil, exceptions.NewUnauthorizedError("Invalid id token")
+	}
+
+	// an unverified email could claim someone else's account, providers that
+	// only hand out verified addresses without the claim are marked trusted
+	if claims.Email == "" || (!bool(claims.EmailVerified) && !oidcClient.Provider.TrustEmail) {
+		return nil, nil, exceptions.NewForbiddenError("Provider account email is not verified")
+	}
+
+	if pending.LinkUserId != 0 {
+		identity, err := s.linkIdentity(ctx, pending.LinkUserId, name, claims)
+		return nil, identity, err
+	}
+
+	loginResponse, err := s.loginWithIdentity(ctx, name, claims, client)
+	return loginResponse, nil, err
+}
+
+// loginWithIdentity signs in the user linked to the provider account, or
+// creates a new user for it. An existing account with the same email is
+// never taken over; its owner has to sign in and link the provider first.
+func (s AuthServiceImpl) loginWithIdentity(ctx context.Context, provider string, claims *helpers.IdTokenClaims, client model.ClientInfo) (*model.LoginResponse, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	var user *model.User
+	identity, err := s.UserIdentityRepo.FindByProviderAndSubject(ctx, tx, provider, claims.Subject)
+	if err != nil && !errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if identity != nil {
+		user, err = s.UserRepo.FindById(ctx, tx, identity.UserId)
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+	} else {
+		user, err = s.UserRepo.FindByEmail(ctx, tx, claims.Email)
+		if err != nil && !errors.Is(err, exceptions.NotFoundError{}) {
+			_ = tx.Rollback()
+			return nil, err
+		}
+
+		if user != nil {
+			// users created by this provider before identities were
+			// recorded are linked on their next sign in; anyone who has
+			// identities already manages them through link and unlink
+			identities, err := s.UserIdentityRepo.FindAllByUserId(ctx, tx, user.Id)
+			if err != nil {
+				_ = tx.Rollback()
+				return nil, err
+			}
+
+			if user.Provider != provider || len(identities) > 0 {
+				_ = tx.Rollback()
+				s.AuditLog.Record(ctx, model.AuditOauthLogin, model.AuditFailure, user, "", client, provider+": email belongs to another account")
+				return nil, exceptions.NewHttpConflictError("An account with this email already exists, sign in with your password and link your " + provider + " account from there")
+			}
+		} else {
+			now := time.Now()
+			user, err = s.UserRepo.Save(ctx, tx, &model.User{
+				Email:           claims.Email,
+				Provider:        provider,
+				EmailVerifiedAt: &now,
+			})
+			if err != nil {
+				_ = tx.Rollback()
+				return nil, err
+			}
+		}
+
+		_, err = s.UserIdentityRepo.Save(ctx, tx, &model.UserIdentity{
+			UserId:    user.Id,
+			Provider:  provider,
+			Subject:   claims.Subject,
+			Email:     claims.Email,
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if user.TotpEnabledAt != nil {
+		// keep the identity recorded above even though the login has to
+		// wait for the second factor
+		_ = tx.Commit()
+		s.AuditLog.Record(ctx, model.AuditOauthLogin, model.AuditSuccess, user, "", client, provider+": two-factor required")
+		return s.twoFactorChallenge(user)
+	}
+
+	tokens, err := s.createSession(ctx, tx, user, client)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	s.AuditLog.Record(ctx, model.AuditOauthLogin, model.AuditSuccess, user, "", client, provider)
+	s.notifyNewDevice(ctx, user, tokens, client)
+
+	loginResponse := model.LoginResponse{
+		Id:           user.Id,
+		Email:        user.Email,
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	}
+
+	return &loginResponse, nil
+}
+
+func (s AuthServiceImpl) linkIdentity(ctx context.Context, userId int, provider string, claims *helpers.IdTokenClaims) (*model.IdentityResponse, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	identity, err := s.UserIdentityRepo.FindByProviderAndSubject(ctx, tx, provider, claims.Subject)
+	if err != nil && !errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if identity != nil {
+		_ = tx.Rollback()
+		if identity.UserId != userId {
+			return nil, exceptions.NewHttpConflictError("This " + provider + " account is already linked to another user")
+		}
+		return toIdentityResponse(identity), nil
+	}
+
+	_, err = s.UserRepo.FindById(ctx, tx, userId)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	err = s.ensureProviderNotLinked(ctx, tx, userId, provider)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	identity, err = s.UserIdentityRepo.Save(ctx, tx, &model.UserIdentity{
+		UserId:    userId,
+		Provider:  provider,
+		Subject:   claims.Subject,
+		Email:     claims.Email,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	return toIdentityResponse(identity), nil
+}
+
+func (s AuthServiceImpl) ensureProviderNotLinked(ctx context.Context, tx *sql.Tx, userId int, provider string) error {
+	identities, err := s.UserIdentityRepo.FindAllByUserId(ctx, tx, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, identity := range identities {
+		if identity.Provider == provider {
+			return exceptions.NewHttpConflictError("Another " + provider + " account is already linked to this user")
+		}
+	}
+
+	return nil
+}
+
+func toIdentityResponse(identity *model.UserIdentity) *model.IdentityResponse {
+	return &model.IdentityResponse{
+		Provider:  identity.Provider,
+		Email:     identity.Email,
+		CreatedAt: identity.CreatedAt,
+	}
+}
+
+func (s AuthServiceImpl) GetIdentities(ctx context.Context, user *model.User) (*model.IdentitiesResponse, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	// the user from the request context carries no credentials
+	user, err = s.UserRepo.FindById(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	identities, err := s.UserIdentityRepo.FindAllByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	resp := &model.IdentitiesResponse{
+		HasPassword: user.Password != "",
+		Identities:  make([]model.IdentityResponse, 0, len(identities)),
+	}
+	for i := range identities {
+		resp.Identities = append(resp.Identities, *toIdentityResponse(&identities[i]))
+	}
+
+	return resp, nil
+}
+
+// UnlinkIdentity removes a provider from the account as long as the user is
+// left with another way to sign in.
+func (s AuthServiceImpl) UnlinkIdentity(ctx context.Context, user *model.User, provider string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	user, err = s.UserRepo.FindById(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	identities, err := s.UserIdentityRepo.FindAllByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	remaining := 0
+	for _, identity := range identities {
+		if identity.Provider != provider {
+			remaining++
+		}
+	}
+
+	if remaining == len(identities) {
+		_ = tx.Rollback()
+		return exceptions.NewHttpNotFoundError("Identity not found")
+	}
+
+	if user.Password == "" && remaining == 0 {
+		_ = tx.Rollback()
+		return exceptions.NewBadRequestError("Set a password before unlinking your only sign in method")
+	}
+
+	_, err = s.UserIdentityRepo.DeleteByUserIdAndProvider(ctx, tx, user.Id, provider)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	// the account must not count as created by the provider any more, or the
+	// next sign in with it would link it again
+	if user.Provider == provider {
+		err = s.UserRepo.UpdateProvider(ctx, tx, user.Id, EmailProvider)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	_ = tx.Commit()
+
+	return nil
+}
+
+func (s AuthServiceImpl) Refresh(ctx context.Context, req model.RefreshTokenRequest, client model.ClientInfo) (*model.RefreshTokenResponse, error) {
+	err := s.Validate.Struct(req)
+	if err != nil {
+		return nil, exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+	}
 
-	if user == nil {
-		user = &model.User{
-			Email:    googleUserInfo.Email,
-			Provider: GoogleProvider,
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	refreshToken, err := s.RefreshTokenRepo.FindByToken(ctx, tx, helpers.HashToken(req.RefreshToken))
+	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
+		return nil, exceptions.NewUnauthorizedError("Invalid refresh token")
+	} else if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	// a refresh token is single use, presenting it twice means it leaked,
+	// so the whole token family (the session) is revoked
+	marked, err := s.RefreshTokenRepo.MarkUsed(ctx, tx, refreshToken.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if !marked {
+		err = s.SessionRepo.Revoke(ctx, tx, refreshToken.SessionId)
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+
+		session, err := s.SessionRepo.FindById(ctx, tx, refreshToken.SessionId)
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, err
 		}
 
-		user, err = s.UserRepo.Save(ctx, tx, user)
+		user, err := s.UserRepo.FindById(ctx, tx, session.UserId)
 		if err != nil {
 			_ = tx.Rollback()
 			return nil, err
 		}
+
+		_ = tx.Commit()
+		s.AuditLog.Record(ctx, model.AuditRefreshTokenReuse, model.AuditFailure, user, "", client, fmt.Sprintf("session %d revoked", session.Id))
+		err = s.SessionCache.Forget(ctx, user.Id)
+		if err != nil {
+			return nil, err
+		}
+		return nil, exceptions.NewUnauthorizedError("Invalid refresh token")
+	}
+
+	session, err := s.SessionRepo.FindById(ctx, tx, refreshToken.SessionId)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	now := time.Now()
+	if refreshToken.ExpiresAt.Before(now) || !s.SessionLifetime.Active(session, now) {
+		_ = tx.Rollback()
+		return nil, exceptions.NewUnauthorizedError("Invalid refresh token")
+	}
+
+	accessTokenTTL := s.Cnf.Env.GetDuration("ACCESS_TOKEN_TTL")
+	refreshTokenTTL := s.Cnf.Env.GetDuration("REFRESH_TOKEN_TTL")
+
+	session.Token = uuid.NewString()
+	session.AccessExpiresAt = s.SessionLifetime.Cap(session, now.Add(accessTokenTTL))
+	session.ExpiresAt = s.SessionLifetime.Cap(session, now.Add(refreshTokenTTL))
+	session.LastSeenAt = now
+
+	signedToken, err := s.signAccessToken(session)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	err = s.SessionRepo.UpdateToken(ctx, tx, session)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	newRefreshToken, err := s.issueRefreshToken(ctx, tx, session.Id, session.ExpiresAt)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	// the access tokens of the old session token must stop working; should
+	// this fail they still run out within ACCESS_TOKEN_TTL
+	_ = s.SessionCache.Forget(ctx, session.UserId)
+
+	return &model.RefreshTokenResponse{
+		Token:        signedToken,
+		RefreshToken: newRefreshToken,
+		ExpiresIn:    int(session.AccessExpiresAt.Sub(now).Seconds()),
+	}, nil
+}
+
+func (s AuthServiceImpl) Logout(ctx context.Context, user *model.User, session *model.Session, client model.ClientInfo) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	err = s.SessionRepo.Revoke(ctx, tx, session.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_ = tx.Commit()
+
+	s.AuditLog.Record(ctx, model.AuditLogout, model.AuditSuccess, user, "", client, fmt.Sprintf("session %d", session.Id))
+
+	return s.SessionCache.Forget(ctx, user.Id)
+}
+
+func (s AuthServiceImpl) LogoutAll(ctx context.Context, user *model.User, client model.ClientInfo) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	err = s.SessionRepo.RevokeAllByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
 	}
 
-	token := uuid.NewString()
-	encodedToken := helpers.StringToBase64([]byte(token))
-	encryptedToken, err := helpers.Encrypt(encodedToken, s.Cnf.Env.GetString("APP_KEY"))
+	_ = tx.Commit()
+
+	s.AuditLog.Record(ctx, model.AuditLogoutAll, model.AuditSuccess, user, "", client, "")
+
+	return s.SessionCache.Forget(ctx, user.Id)
+}
+
+func (s AuthServiceImpl) GetSessions(ctx context.Context, user *model.User, current *model.Session) ([]model.SessionResponse, error) {
+	tx, err := s.DB.Begin()
 	if err != nil {
 		return nil, exceptions.NewInternalServerError()
 	}
 
-	_, err = s.SessionRepo.Save(ctx, tx, &model.Session{
-		UserId:    user.Id,
-		Token:     token,
-		ExpiresAt: time.Now().Add(time.Hour * 24 * 7),
-	})
+	sessions, err := s.SessionRepo.FindAllActiveByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	now := time.Now()
+	sessionResponses := []model.SessionResponse{}
+	for _, session := range sessions {
+		if !s.SessionLifetime.Active(&session, now) {
+			continue
+		}
+		sessionResponses = append(sessionResponses, model.SessionResponse{
+			Id:           session.Id,
+			CreatedAt:    session.CreatedAt,
+			LastSeenAt:   session.LastSeenAt,
+			IpAddress:    session.IpAddress,
+			UserAgent:    session.UserAgent,
+			DeviceLabel:  session.DeviceLabel,
+			Current:      current != nil && session.Id == current.Id,
+			Impersonated: session.ImpersonatorId != nil,
+		})
+	}
+
+	return sessionResponses, nil
+}
+
+func (s AuthServiceImpl) RevokeSession(ctx context.Context, user *model.User, sessionId int, client model.ClientInfo) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	session, err := s.SessionRepo.FindById(ctx, tx, sessionId)
+	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
+		return exceptions.NewHttpNotFoundError("Session not found")
+	} else if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if session.UserId != user.Id {
+		_ = tx.Rollback()
+		return exceptions.NewHttpNotFoundError("Session not found")
+	}
+
+	err = s.SessionRepo.Revoke(ctx, tx, session.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_ = tx.Commit()
+
+	s.AuditLog.Record(ctx, model.AuditSessionRevoked, model.AuditSuccess, user, "", client, fmt.Sprintf("session %d", session.Id))
+
+	return s.SessionCache.Forget(ctx, user.Id)
+}
+
+func (s AuthServiceImpl) VerifyEmail(ctx context.Context, token string) error {
+	claims, err := helpers.VerifyToken(s.Keyring, helpers.VerifyEmailTokenType, token)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid or expired verification link")
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid or expired verification link")
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	user, err := s.UserRepo.FindById(ctx, tx, userId)
+	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
+		return exceptions.NewBadRequestError("Invalid or expired verification link")
+	} else if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if user.EmailVerifiedAt != nil {
+		_ = tx.Rollback()
+		return nil
+	}
+
+	err = s.UserRepo.MarkEmailVerified(ctx, tx, user.Id, time.Now())
 	if err != nil {
 		_ = tx.Rollback()
+		return err
+	}
+
+	_ = tx.Commit()
+
+	_ = s.SessionCache.Forget(ctx, user.Id)
+
+	return nil
+}
+
+func (s AuthServiceImpl) ResendVerificationEmail(ctx context.Context, user *model.User) error {
+	if user.EmailVerifiedAt != nil {
+		return exceptions.NewBadRequestError("Email already verified")
+	}
+
+	err := s.sendVerificationEmail(user)
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
+
+func (s AuthServiceImpl) sendVerificationEmail(user *model.User) error {
+	ttl := s.Cnf.Env.GetDuration("EMAIL_VERIFICATION_TOKEN_TTL")
+	token, err := helpers.SignToken(s.Keyring, helpers.VerifyEmailTokenType, strconv.Itoa(user.Id), time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+
+	verifyEmailData := config.VerifyEmailData{
+		Email:           user.Email,
+		VerificationUrl: s.Cnf.Env.GetString("APP_URL") + "/api/auth/email/verify?token=" + url.QueryEscape(token),
+		ExpiresIn:       humanizeDuration(ttl),
+	}
+
+	return s.sendTemplateEmail(user.Email, "Verify Your Email", VerifyEmailTemplateEmail, verifyEmailData)
+}
+
+func (s AuthServiceImpl) UnlockAccount(ctx context.Context, token string) error {
+	claims, err := helpers.VerifyToken(s.Keyring, helpers.UnlockAccountTokenType, token)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid or expired unlock link")
+	}
+
+	return s.LoginThrottle.Reset(ctx, claims.Subject)
+}
+
+func (s AuthServiceImpl) sendUnlockAccountEmail(user *model.User) error {
+	lockout := s.Cnf.Env.GetDuration("LOGIN_LOCKOUT_DURATION")
+	token, err := helpers.SignToken(s.Keyring, helpers.UnlockAccountTokenType, user.Email, time.Now().Add(lockout))
+	if err != nil {
+		return err
+	}
+
+	unlockAccountData := config.UnlockAccountData{
+		Email:           user.Email,
+		UnlockUrl:       s.Cnf.Env.GetString("APP_URL") + "/api/auth/unlock?token=" + url.QueryEscape(token),
+		LockoutDuration: humanizeDuration(lockout),
+	}
+
+	return s.sendTemplateEmail(user.Email, "Your Account Has Been Locked", UnlockAccountTemplateEmail, unlockAccountData)
+}
+
+// ReportDevice handles the "this wasn't me" link of a new device email. It
+// signs every session of the user out, which ends their refresh tokens too,
+// forgets the device and requires a password reset before the password can be
+// used to sign in again. Each link works once, so it cannot lock the account
+// again after the reset.
+func (s AuthServiceImpl) ReportDevice(ctx context.Context, req model.ReportDeviceRequest, client model.ClientInfo) error {
+	err := s.Validate.Struct(req)
+	if err != nil {
+		return exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+	}
+
+	claims, err := helpers.VerifyToken(s.Keyring, helpers.ReportDeviceTokenType, req.Token)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid or expired report link")
+	}
+
+	sessionId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return exceptions.NewBadRequestError("Invalid or expired report link")
+	}
+
+	usedKey := "device_reported:" + claims.Subject
+	fresh, err := s.RedisClient.SetNX(ctx, usedKey, 1, time.Until(time.Unix(claims.ExpiresAt, 0))).Result()
+	if err != nil {
+		log.Println("error while set device report to redis", err)
+		return exceptions.NewInternalServerError()
+	}
+
+	if !fresh {
+		return exceptions.NewBadRequestError("This report link has already been used")
+	}
+
+	user, err := s.reportDevice(ctx, sessionId, client)
+	if err != nil {
+		// the link stays usable when the report did not go through
+		_ = s.RedisClient.Del(ctx, usedKey).Err()
+		return err
+	}
+
+	return s.SessionCache.Forget(ctx, user.Id)
+}
+
+func (s AuthServiceImpl) reportDevice(ctx context.Context, sessionId int, client model.ClientInfo) (*model.User, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
 		return nil, exceptions.NewInternalServerError()
 	}
 
+	session, err := s.SessionRepo.FindById(ctx, tx, sessionId)
+	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
+		return nil, exceptions.NewBadRequestError("Invalid or expired report link")
+	} else if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	user, err := s.UserRepo.FindById(ctx, tx, session.UserId)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	err = s.SessionRepo.RevokeAllByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	err = s.KnownDeviceRepo.Forget(ctx, tx, user.Id, helpers.DeviceFingerprint(session.IpAddress, session.UserAgent))
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	err = s.UserRepo.RequirePasswordReset(ctx, tx, user.Id, time.Now())
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
 	_ = tx.Commit()
 
-	loginResponse := model.LoginResponse{
-		Id:    user.Id,
-		Email: user.Email,
-		Token: encryptedToken,
+	s.AuditLog.Record(ctx, model.AuditDeviceReported, model.AuditSuccess, user, "", client, fmt.Sprintf("session %d", session.Id))
+
+	return user, nil
+}
+
+func (s AuthServiceImpl) sendTemplateEmail(to string, subject string, emailTemplate string, data any) error {
+	tmpl, err := template.New("email").Parse(emailTemplate)
+	if err != nil {
+		log.Println("error while parse template", err)
+		return err
 	}
 
-	return &loginResponse, nil
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		log.Println("error while execute template", err)
+		return err
+	}
+
+	err = s.Mailer.SendEmail(to, subject, body.String())
+	if err != nil {
+		log.Println("error while send email", err)
+		return err
+	}
+
+	return nil
+}
+
+func humanizeDuration(d time.Duration) string {
+	if d >= time.Hour {
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+
+	return fmt.Sprintf("%d minutes", int(d.Minutes()))
 }
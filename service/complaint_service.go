@@ -36,6 +36,7 @@ type ComplaintServiceImpl struct {
 	DB            *sql.DB
 	ComplaintRepo repository.ComplaintRepository
 	DrugRepo      repository.DrugRepository
+	ProfileRepo   repository.UserProfileRepository
 }
 
 func NewComplaintService(
@@ -46,6 +47,7 @@ func NewComplaintService(
 	complaintRepo repository.ComplaintRepository,
 	db *sql.DB,
 	drugRepo repository.DrugRepository,
+	profileRepo repository.UserProfileRepository,
 ) ComplaintService {
 	return &ComplaintServiceImpl{
 		Validate:      validate,
@@ -55,6 +57,7 @@ func NewComplaintService(
 		ComplaintRepo: complaintRepo,
 		DB:            db,
 		DrugRepo:      drugRepo,
+		ProfileRepo:   profileRepo,
 	}
 }
 
@@ -100,19 +103,29 @@ func (A ComplaintServiceImpl) ExternalWound(ctx context.Context, req model.Compl
 		return nil, err
 	}
 
+	patientContext, err := A.patientContext(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
 	// upload to gemini and s3 concurrently
-	fileURIs, location, err := uploadFilesConcurrently(ctx, &req, A)
+	geminiFile, location, err := uploadFilesConcurrently(ctx, &req, A)
 	if err != nil {
 		return nil, err
 	}
 
+	parts := []genai.Part{
+		genai.FileData{URI: geminiFile.URI},
+	}
+	if patientContext != "" {
+		parts = append(parts, genai.Text(patientContext))
+	}
+
 	session := generativeModel.StartChat()
 	session.History = []*genai.Content{
 		{
-			Role: "user",
-			Parts: []genai.Part{
-				genai.FileData{URI: fileURIs},
-			},
+			Role:  "user",
+			Parts: parts,
 		},
 	}
 
@@ -140,13 +153,14 @@ func (A ComplaintServiceImpl) ExternalWound(ctx context.Context, req model.Compl
 
 	generatedId := uuid.NewString()
 	complaint := model.Complaint{
-		Id:            generatedId,
-		UserId:        user.Id,
-		Title:         geminiComplaintResponse.SuggestedTitle,
-		ComplaintsMsg: req.Complaint,
-		Response:      jsonResp,
-		ImageUrl:      location,
-		CreatedAt:     time.Now(),
+		Id:             generatedId,
+		UserId:         user.Id,
+		Title:          geminiComplaintResponse.SuggestedTitle,
+		ComplaintsMsg:  req.Complaint,
+		Response:       jsonResp,
+		ImageUrl:       location,
+		GeminiFileName: geminiFile.Name,
+		CreatedAt:      time.Now(),
 	}
 
 	_, err = A.ComplaintRepo.Save(ctx, tx, &complaint)
@@ -166,6 +180,26 @@ func (A ComplaintServiceImpl) ExternalWound(ctx context.Context, req model.Compl
 	return &externalWoundResponse, nil
 }
 
+func (A ComplaintServiceImpl) patientContext(ctx context.Context, user *model.User) (string, error) {
+	tx, err := A.DB.Begin()
+	if err != nil {
+		return "", exceptions.NewInternalServerError()
+	}
+
+	profile, err := A.ProfileRepo.FindByUserId(ctx, tx, user.Id)
+	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
+		return "", nil
+	} else if err != nil {
+		_ = tx.Rollback()
+		return "", err
+	}
+
+	_ = tx.Commit()
+
+	return profilePromptContext(profile, time.Now()), nil
+}
+
 func (A ComplaintServiceImpl) GetById(ctx context.Context, complaintId string, user *model.User) (*model.ComplaintResponse, error) {
 	tx, err := A.DB.Begin()
 	if err != nil {
@@ -179,7 +213,7 @@ func (A ComplaintServiceImpl) GetById(ctx context.Context, complaintId string, u
 		return nil, err
 	}
 
-	if complaint.UserId != user.Id {
+	if complaint.UserId != user.Id && !user.HasPermission(model.PermissionReadAnyComplaint) {
 		return nil, exceptions.NewForbiddenError("You are not authorized to access this complaint")
 	}
 
@@ -244,7 +278,7 @@ func (A ComplaintServiceImpl) GetDrugRecommendations(ctx context.Context, compla
 		return nil, err
 	}
 
-	if complaint.UserId != user.Id {
+	if complaint.UserId != user.Id && !user.HasPermission(model.PermissionReadAnyComplaint) {
 		return nil, exceptions.NewForbiddenError("You are not authorized to access this complaint")
 	}
 
@@ -317,10 +351,10 @@ func (A ComplaintServiceImpl) Update(ctx context.Context, req model.UpdateCompla
 	return &complaintResponse, nil
 }
 
-func uploadFilesConcurrently(ctx context.Context, req *model.ComplaintRequest, A ComplaintServiceImpl) (fileURIs string, location string, err error) {
+func uploadFilesConcurrently(ctx context.Context, req *model.ComplaintRequest, A ComplaintServiceImpl) (geminiFile *genai.File, location string, err error) {
 	var wg sync.WaitGroup
 
-	fileURIsCh := make(chan string, 1)
+	geminiFileCh := make(chan *genai.File, 1)
 	locationCh := make(chan string, 1)
 	errorCh := make(chan error, 2)
 
@@ -334,12 +368,12 @@ func uploadFilesConcurrently(ctx context.Context, req *model.ComplaintRequest, A
 		}
 		defer open.Close()
 
-		uri, err := helpers.UploadToGemini(ctx, A.AIClient.Genai, open, "image/png")
+		file, err := helpers.UploadToGemini(ctx, A.AIClient.Genai, open, "image/png")
 		if err != nil {
 			errorCh <- err
 			return
 		}
-		fileURIsCh <- uri
+		geminiFileCh <- file
 	}()
 
 	wg.Add(1)
@@ -362,18 +396,18 @@ func uploadFilesConcurrently(ctx context.Context, req *model.ComplaintRequest, A
 
 	wg.Wait()
 
-	close(fileURIsCh)
+	close(geminiFileCh)
 	close(locationCh)
 	close(errorCh)
 
 	if len(errorCh) > 0 {
 		err := <-errorCh
 		log.Println("Error while uploading:", err)
-		return "", "", exceptions.NewInternalServerError()
+		return nil, "", exceptions.NewInternalServerError()
 	}
 
-	fileURI := <-fileURIsCh
+	geminiFile = <-geminiFileCh
 	location = <-locationCh
 
-	return fileURI, location, nil
+	return geminiFile, location, nil
 }
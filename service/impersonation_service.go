@@ -0,0 +1,153 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/helpers"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+type ImpersonationService interface {
+	Impersonate(ctx context.Context, admin *model.User, session *model.Session, userId int, req model.ImpersonateRequest, client model.ClientInfo) (*model.ImpersonateResponse, error)
+}
+
+type ImpersonationServiceImpl struct {
+	UserRepo    repository.UserRepository
+	SessionRepo repository.SessionRepository
+	RoleRepo    repository.RoleRepository
+	DB          *sql.DB
+	Validate    *validator.Validate
+	Cnf         *config.Config
+	Keyring     *config.Keyring
+	AuditLog    *AuditLog
+}
+
+func NewImpersonationService(
+	userRepo repository.UserRepository,
+	sessionRepo repository.SessionRepository,
+	roleRepo repository.RoleRepository,
+	DB *sql.DB,
+	validate *validator.Validate,
+	cnf *config.Config,
+	keyring *config.Keyring,
+	auditLog *AuditLog,
+) *ImpersonationServiceImpl {
+	return &ImpersonationServiceImpl{UserRepo: userRepo, SessionRepo: sessionRepo, RoleRepo: roleRepo, DB: DB, Validate: validate, Cnf: cnf, Keyring: keyring, AuditLog: auditLog}
+}
+
+// Impersonate opens a session as the user for support staff. The session only
+// carries an access token, lives for IMPERSONATION_TTL and is never renewed.
+// Unless writes are allowed the Authenticate middleware rejects every request
+// that could change data.
+func (i ImpersonationServiceImpl) Impersonate(ctx context.Context, admin *model.User, session *model.Session, userId int, req model.ImpersonateRequest, client model.ClientInfo) (*model.ImpersonateResponse, error) {
+	err := i.Validate.Struct(req)
+	if err != nil {
+		return nil, exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+	}
+
+	if session.ImpersonatorId != nil {
+		return nil, exceptions.NewForbiddenError("You cannot impersonate while impersonating")
+	}
+
+	if admin.Id == userId {
+		return nil, exceptions.NewBadRequestError("You cannot impersonate yourself")
+	}
+
+	tx, err := i.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	user, err := i.UserRepo.FindById(ctx, tx, userId)
+	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
+		return nil, exceptions.NewHttpNotFoundError("User not found")
+	} else if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	roles, err := i.RoleRepo.FindRoleNamesByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if slices.Contains(roles, model.RoleAdmin) {
+		_ = tx.Rollback()
+		return nil, exceptions.NewForbiddenError("Admins cannot be impersonated")
+	}
+
+	// acting as the user must not hand out permissions the caller does not hold
+	permissions, err := i.RoleRepo.FindPermissionNamesByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	for _, permission := range permissions {
+		if !admin.HasPermission(permission) {
+			_ = tx.Rollback()
+			return nil, exceptions.NewForbiddenError("You cannot impersonate a user holding permissions you do not have")
+		}
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(i.Cnf.Env.GetDuration("IMPERSONATION_TTL"))
+	impersonation, err := i.SessionRepo.Save(ctx, tx, &model.Session{
+		UserId:                user.Id,
+		Token:                 uuid.NewString(),
+		ExpiresAt:             expiresAt,
+		AccessExpiresAt:       expiresAt,
+		CreatedAt:             now,
+		LastSeenAt:            now,
+		IpAddress:             client.IpAddress,
+		UserAgent:             client.UserAgent,
+		DeviceLabel:           "Support session",
+		ImpersonatorId:        &admin.Id,
+		ImpersonationReadOnly: !req.AllowWrites,
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	signedToken, err := helpers.SignToken(i.Keyring, helpers.AccessTokenType, impersonation.Token, impersonation.AccessExpiresAt)
+	if err != nil {
+		_ = tx.Rollback()
+		log.Println("error while sign impersonation token", err)
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	_ = tx.Commit()
+
+	mode := "read only"
+	if req.AllowWrites {
+		mode = "writes allowed"
+	}
+	reason := strings.TrimSpace(req.Reason)
+	i.AuditLog.Record(ctx, model.AuditImpersonation, model.AuditSuccess, admin, "", client,
+		fmt.Sprintf("user %d, session %d, %s: %s", user.Id, impersonation.Id, mode, reason))
+	i.AuditLog.Record(ctx, model.AuditImpersonated, model.AuditSuccess, user, "", client,
+		fmt.Sprintf("by user %d, session %d, %s: %s", admin.Id, impersonation.Id, mode, reason))
+
+	return &model.ImpersonateResponse{
+		UserId:    user.Id,
+		Email:     user.Email,
+		Token:     signedToken,
+		ExpiresIn: int(impersonation.AccessExpiresAt.Sub(now).Seconds()),
+		ExpiresAt: impersonation.AccessExpiresAt,
+		ReadOnly:  impersonation.ImpersonationReadOnly,
+	}, nil
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// LoginThrottle keeps failed login counters per account and per IP in redis.
+// Every failure makes the next attempt wait a little longer, and reaching the
+// configured threshold locks the account or IP for a while.
+type LoginThrottle struct {
+	RedisClient *redis.Client
+	Cnf         *config.Config
+}
+
+func NewLoginThrottle(redisClient *redis.Client, cnf *config.Config) *LoginThrottle {
+	return &LoginThrottle{RedisClient: redisClient, Cnf: cnf}
+}
+
+func loginAccountKey(prefix string, email string) string {
+	return fmt.Sprintf("%s:account:%s", prefix, strings.ToLower(email))
+}
+
+func loginIpKey(prefix string, ip string) string {
+	return fmt.Sprintf("%s:ip:%s", prefix, ip)
+}
+
+// Check rejects the attempt when the account or IP is locked or still has to
+// wait out the delay caused by its previous failure.
+func (l *LoginThrottle) Check(ctx context.Context, email string, ip string) error {
+	exists, err := l.RedisClient.Exists(ctx, loginAccountKey("login_lock", email), loginIpKey("login_lock", ip)).Result()
+	if err != nil {
+		log.Println("error while check login lock", err)
+		return exceptions.NewInternalServerError()
+	}
+
+	if exists > 0 {
+		return exceptions.NewTooManyRequestsError("Too many failed login attempts, the account is temporarily locked")
+	}
+
+	wait, err := l.RedisClient.PTTL(ctx, loginAccountKey("login_delay", email)).Result()
+	if err != nil {
+		log.Println("error while check login delay", err)
+		return exceptions.NewInternalServerError()
+	}
+
+	if wait > 0 {
+		return exceptions.NewTooManyRequestsError(fmt.Sprintf("Too many failed login attempts, try again in %d seconds", int(math.Ceil(wait.Seconds()))))
+	}
+
+	return nil
+}
+
+// RecordFailure counts a failed attempt and reports whether it caused the
+// account to be locked.
+func (l *LoginThrottle) RecordFailure(ctx context.Context, email string, ip string) (bool, error) {
+	window := l.Cnf.Env.GetDuration("LOGIN_ATTEMPT_WINDOW")
+	lockout := l.Cnf.Env.GetDuration("LOGIN_LOCKOUT_DURATION")
+
+	accountFailures, err := l.increment(ctx, loginAccountKey("login_failed", email), window)
+	if err != nil {
+		return false, err
+	}
+
+	ipFailures, err := l.increment(ctx, loginIpKey("login_failed", ip), window)
+	if err != nil {
+		return false, err
+	}
+
+	if ipFailures >= l.Cnf.Env.GetInt64("LOGIN_MAX_ATTEMPTS_PER_IP") {
+		err = l.RedisClient.Set(ctx, loginIpKey("login_lock", ip), 1, lockout).Err()
+		if err != nil {
+			log.Println("error while lock ip", err)
+			return false, exceptions.NewInternalServerError()
+		}
+	}
+
+	if accountFailures >= l.Cnf.Env.GetInt64("LOGIN_MAX_ATTEMPTS_PER_ACCOUNT") {
+		err = l.RedisClient.Set(ctx, loginAccountKey("login_lock", email), 1, lockout).Err()
+		if err != nil {
+			log.Println("error while lock account", err)
+			return false, exceptions.NewInternalServerError()
+		}
+
+		_ = l.RedisClient.Del(ctx, loginAccountKey("login_failed", email), loginAccountKey("login_delay", email)).Err()
+		return true, nil
+	}
+
+	err = l.RedisClient.Set(ctx, loginAccountKey("login_delay", email), 1, l.delay(accountFailures)).Err()
+	if err != nil {
+		log.Println("error while set login delay", err)
+		return false, exceptions.NewInternalServerError()
+	}
+
+	return false, nil
+}
+
+// Reset clears the account counters after a successful login or unlock.
+func (l *LoginThrottle) Reset(ctx context.Context, email string) error {
+	err := l.RedisClient.Del(ctx, loginAccountKey("login_failed", email), loginAccountKey("login_delay", email), loginAccountKey("login_lock", email)).Err()
+	if err != nil {
+		log.Println("error while reset login throttle", err)
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
+
+func (l *LoginThrottle) increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	count, err := l.RedisClient.Incr(ctx, key).Result()
+	if err != nil {
+		log.Println("error while increment login failures", err)
+		return 0, exceptions.NewInternalServerError()
+	}
+
+	if count == 1 {
+		_ = l.RedisClient.Expire(ctx, key, window).Err()
+	}
+
+	return count, nil
+}
+
+// delay doubles the base delay for every failure, capped at the max delay.
+func (l *LoginThrottle) delay(failures int64) time.Duration {
+	base := l.Cnf.Env.GetDuration("LOGIN_DELAY_BASE")
+	maxDelay := l.Cnf.Env.GetDuration("LOGIN_DELAY_MAX")
+
+	delay := base * time.Duration(math.Pow(2, float64(failures-1)))
+	if delay > maxDelay || delay <= 0 {
+		return maxDelay
+	}
+
+	return delay
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/helpers"
+	"context"
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/singleflight"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// OidcClient resolves the endpoints of one configured provider. The
+// discovery document is cached for the discovery ttl and reused past it when
+// the provider cannot be reached; signing keys are cached by the JwksCache.
+// Concurrent requests share a single discovery fetch, made without holding
+// the lock.
+type OidcClient struct {
+	Provider     *config.OidcProvider
+	HttpClient   *http.Client
+	DiscoveryTtl time.Duration
+
+	mu           sync.Mutex
+	discovery    *helpers.OidcDiscovery
+	discoveredAt time.Time
+	jwks         *helpers.JwksCache
+	fetches      singleflight.Group
+}
+
+func NewOidcClients(oauthClient *config.OauthClient, cnf *config.Config) map[string]*OidcClient {
+	clients := make(map[string]*OidcClient, len(oauthClient.Providers))
+	for name, provider := range oauthClient.Providers {
+		clients[name] = &OidcClient{
+			Provider:     provider,
+			HttpClient:   oauthClient.HttpClient,
+			DiscoveryTtl: cnf.Env.GetDuration("OIDC_DISCOVERY_TTL"),
+		}
+	}
+	return clients
+}
+
+func (o *OidcClient) resolve(ctx context.Context) (*oauth2.Config, *helpers.JwksCache, error) {
+	authUrl, tokenUrl, jwksUrl := o.Provider.AuthUrl, o.Provider.TokenUrl, o.Provider.JwksUrl
+	if authUrl == "" || tokenUrl == "" || jwksUrl == "" {
+		discovery, err := o.loadDiscovery(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if authUrl == "" {
+			authUrl = discovery.AuthorizationEndpoint
+		}
+		if tokenUrl == "" {
+			tokenUrl = discovery.TokenEndpoint
+		}
+		if jwksUrl == "" {
+			jwksUrl = discovery.JwksUri
+		}
+	}
+
+	o.mu.Lock()
+	if o.jwks == nil || o.jwks.Url != jwksUrl {
+		o.jwks = helpers.NewJwksCache(jwksUrl, o.HttpClient)
+	}
+	jwks := o.jwks
+	o.mu.Unlock()
+
+	return &oauth2.Config{
+		ClientID:     o.Provider.ClientId,
+		ClientSecret: o.Provider.ClientSecret,
+		RedirectURL:  o.Provider.RedirectUrl,
+		Scopes:       o.Provider.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   authUrl,
+			TokenURL:  tokenUrl,
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}, jwks, nil
+}
+
+func (o *OidcClient) loadDiscovery(ctx context.Context) (*helpers.OidcDiscovery, error) {
+	o.mu.Lock()
+	cached, discoveredAt := o.discovery, o.discoveredAt
+	o.mu.Unlock()
+
+	if cached != nil && time.Since(discoveredAt) <= o.DiscoveryTtl {
+		return cached, nil
+	}
+
+	// the fetch is shared, so one caller going away must not cancel it for
+	// the others
+	fetched, err, _ := o.fetches.Do("discovery", func() (any, error) {
+		return helpers.FetchOidcDiscovery(context.WithoutCancel(ctx), o.HttpClient, o.Provider.Issuer)
+	})
+	if err != nil && cached == nil {
+		return nil, err
+	} else if err != nil {
+		log.Printf("error while refresh %s discovery, using cached document: %v", o.Provider.Name, err)
+		return cached, nil
+	}
+
+	discovery := fetched.(*helpers.OidcDiscovery)
+
+	o.mu.Lock()
+	o.discovery = discovery
+	o.discoveredAt = time.Now()
+	o.mu.Unlock()
+
+	return discovery, nil
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/helpers"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newFakeDiscoveryServer(t *testing.T, fetches *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			http.NotFound(w, r)
+			return
+		}
+
+		fetches.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		_ = json.NewEncoder(w).Encode(helpers.OidcDiscovery{
+			Issuer:                server.URL,
+			AuthorizationEndpoint: server.URL + "/authorize",
+			TokenEndpoint:         server.URL + "/token",
+			JwksUri:               server.URL + "/jwks",
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestOidcClientResolveDiscoversEndpoints(t *testing.T) {
+	var fetches atomic.Int32
+	server := newFakeDiscoveryServer(t, &fetches)
+
+	client := &OidcClient{
+		Provider:     &config.OidcProvider{Name: "fake", Issuer: server.URL, ClientId: "client-id", RedirectUrl: "http://localhost/callback"},
+		HttpClient:   server.Client(),
+		DiscoveryTtl: time.Hour,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			oauthConfig, jwks, err := client.resolve(context.Background())
+			if err != nil {
+				t.Errorf("resolve() error = %v", err)
+				return
+			}
+			if oauthConfig.Endpoint.TokenURL != server.URL+"/token" || jwks.Url != server.URL+"/jwks" {
+				t.Errorf("resolve() endpoints = %+v, jwks %s", oauthConfig.Endpoint, jwks.Url)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := fetches.Load(); got != 1 {
+		t.Fatalf("discovery fetched %d times, want 1", got)
+	}
+}
+
+func TestOidcClientResolveKeepsCachedDiscovery(t *testing.T) {
+	var fetches atomic.Int32
+	server := newFakeDiscoveryServer(t, &fetches)
+
+	client := &OidcClient{
+		Provider:     &config.OidcProvider{Name: "fake", Issuer: server.URL},
+		HttpClient:   server.Client(),
+		DiscoveryTtl: time.Hour,
+	}
+
+	_, first, err := client.resolve(context.Background())
+	if err != nil {
+		t.Fatalf("resolve() error = %v", err)
+	}
+
+	// an expired document is still used while the provider is unreachable
+	server.Close()
+	client.discoveredAt = time.Now().Add(-2 * time.Hour)
+
+	oauthConfig, second, err := client.resolve(context.Background())
+	if err != nil {
+		t.Fatalf("resolve() with the provider down error = %v", err)
+	}
+	if oauthConfig.Endpoint.AuthURL != server.URL+"/authorize" || second != first {
+		t.Fatalf("resolve() did not reuse the cached discovery")
+	}
+}
+
+func TestOidcClientResolveSkipsDiscoveryForExplicitEndpoints(t *testing.T) {
+	client := &OidcClient{
+		Provider: &config.OidcProvider{
+			Name:     "fake",
+			Issuer:   "http://127.0.0.1:0",
+			AuthUrl:  "http://fake.test/authorize",
+			TokenUrl: "http://fake.test/token",
+			JwksUrl:  "http://fake.test/jwks",
+		},
+		HttpClient:   http.DefaultClient,
+		DiscoveryTtl: time.Hour,
+	}
+
+	oauthConfig, jwks, err := client.resolve(context.Background())
+	if err != nil {
+		t.Fatalf("resolve() error = %v", err)
+	}
+	if oauthConfig.Endpoint.AuthURL != "http://fake.test/authorize" || jwks.Url != "http://fake.test/jwks" {
+		t.Fatalf("resolve() endpoints = %+v, jwks %s", oauthConfig.Endpoint, jwks.Url)
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/helpers"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"strings"
+)
+
+const (
+	ForgetPasswordOtpPurpose = "forget_password"
+)
+
+const (
+	otpValid           = "valid"
+	otpTooManyAttempts = "too_many_attempts"
+)
+
+// consumeOtpAttempt atomically counts an attempt against an existing code and
+// compares it with the candidate hashes (ARGV[2..]), one per usable key. The
+// code is removed when it matches or the attempt limit (ARGV[1]) is used up,
+// so the same code can never be accepted twice.
+var consumeOtpAttempt = redis.NewScript(`
+local hash = redis.call('HGET', KEYS[1], 'hash')
+if not hash then
+	return false
+end
+local attempts = redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+local maxAttempts = tonumber(ARGV[1])
+if attempts > maxAttempts then
+	redis.call('DEL', KEYS[1])
+	return 'too_many_attempts'
+end
+for i = 2, #ARGV do
+	if ARGV[i] == hash then
+		redis.call('DEL', KEYS[1])
+		return 'valid'
+	end
+end
+if attempts >= maxAttempts then
+	redis.call('DEL', KEYS[1])
+end
+return 'invalid'
+`)
+
+// OtpStore issues short numeric one-time codes for any flow identified by a
+// purpose and a subject (e.g. an email). Codes are kept hashed in redis, are
+// single use and are invalidated after too many wrong guesses.
+type OtpStore struct {
+	RedisClient *redis.Client
+	Cnf         *config.Config
+	Keyring     *config.Keyring
+}
+
+func NewOtpStore(redisClient *redis.Client, cnf *config.Config, keyring *config.Keyring) *OtpStore {
+	return &OtpStore{RedisClient: redisClient, Cnf: cnf, Keyring: keyring}
+}
+
+func otpKey(purpose string, subject string) string {
+	return fmt.Sprintf("otp:%s:%s", purpose, strings.ToLower(subject))
+}
+
+// Issue creates a new code for the subject, replacing any previous one.
+func (o *OtpStore) Issue(ctx context.Context, purpose string, subject string) (string, error) {
+	code, err := helpers.GenerateNumericCode(o.Cnf.Env.GetInt("OTP_LENGTH"))
+	if err != nil {
+		log.Println("error while generate otp", err)
+		return "", exceptions.NewInternalServerError()
+	}
+
+	key := otpKey(purpose, subject)
+	_, err = o.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, "hash", o.hash(o.Keyring.Keys[o.Keyring.CurrentKid], purpose, subject, code), "attempts", 0)
+		pipe.Expire(ctx, key, o.Cnf.Env.GetDuration("OTP_TTL"))
+		return nil
+	})
+	if err != nil {
+		log.Println("error while set otp to redis", err)
+		return "", exceptions.NewInternalServerError()
+	}
+
+	return code, nil
+}
+
+// Verify consumes the code when it matches. Every call counts as an attempt,
+// and once the limit is reached the code is removed. Codes issued before a
+// key rotation keep working while the previous key is within its grace
+// period.
+func (o *OtpStore) Verify(ctx context.Context, purpose string, subject string, code string) error {
+	args := []any{o.Cnf.Env.GetInt64("OTP_MAX_ATTEMPTS")}
+	for kid := range o.Keyring.Keys {
+		if key, ok := o.Keyring.Key(kid); ok {
+			args = append(args, o.hash(key, purpose, subject, code))
+		}
+	}
+
+	result, err := consumeOtpAttempt.Run(ctx, o.RedisClient, []string{otpKey(purpose, subject)}, args...).Text()
+	if err != nil && errors.Is(err, redis.Nil) {
+		return exceptions.NewBadRequestError("Invalid OTP")
+	} else if err != nil {
+		log.Println("error while verify otp in redis", err)
+		return exceptions.NewInternalServerError()
+	}
+
+	switch result {
+	case otpValid:
+		return nil
+	case otpTooManyAttempts:
+		return exceptions.NewBadRequestError("Too many invalid attempts, please request a new OTP")
+	default:
+		return exceptions.NewBadRequestError("Invalid OTP")
+	}
+}
+
+// hash binds the code to its purpose and subject and keys it with the app key
+// so a leaked redis dump cannot be brute forced offline.
+func (o *OtpStore) hash(key []byte, purpose string, subject string, code string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose + ":" + strings.ToLower(subject) + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
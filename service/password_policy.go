@@ -0,0 +1,144 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const breachedPrefixLength = 5
+
+// PasswordPolicy checks new passwords against the configured rules and, when
+// PASSWORD_BREACHED_DIR is set, against a local copy of a breached password
+// list. The list is split k-anonymity style into files named after the first
+// five hex characters of the SHA-1 hash (e.g. 21BD1.txt), each holding
+// "SUFFIX:COUNT" lines, so a check only ever reads one small file.
+type PasswordPolicy struct {
+	Cnf         *config.Config
+	BreachedDir string
+}
+
+func NewPasswordPolicy(cnf *config.Config) *PasswordPolicy {
+	breachedDir := cnf.Env.GetString("PASSWORD_BREACHED_DIR")
+	if breachedDir != "" {
+		info, err := os.Stat(breachedDir)
+		if err != nil || !info.IsDir() {
+			log.Fatal("Can't find the breached password directory : ", breachedDir)
+		}
+	}
+
+	return &PasswordPolicy{Cnf: cnf, BreachedDir: breachedDir}
+}
+
+// Check returns a message for every rule the password breaks, none when it
+// is acceptable.
+func (p *PasswordPolicy) Check(password string, email string) ([]string, error) {
+	var msgs []string
+
+	minLength := p.Cnf.Env.GetInt("PASSWORD_MIN_LENGTH")
+	if utf8.RuneCountInString(password) < minLength {
+		msgs = append(msgs, fmt.Sprintf("The password must be at least %d characters", minLength))
+	}
+
+	var hasLower, hasUpper, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+
+	if p.Cnf.Env.GetBool("PASSWORD_REQUIRE_LOWERCASE") && !hasLower {
+		msgs = append(msgs, "The password must contain a lowercase letter")
+	}
+	if p.Cnf.Env.GetBool("PASSWORD_REQUIRE_UPPERCASE") && !hasUpper {
+		msgs = append(msgs, "The password must contain an uppercase letter")
+	}
+	if p.Cnf.Env.GetBool("PASSWORD_REQUIRE_DIGIT") && !hasDigit {
+		msgs = append(msgs, "The password must contain a number")
+	}
+	if p.Cnf.Env.GetBool("PASSWORD_REQUIRE_SYMBOL") && !hasSymbol {
+		msgs = append(msgs, "The password must contain a symbol")
+	}
+
+	if p.Cnf.Env.GetBool("PASSWORD_DISALLOW_EMAIL") && containsEmail(password, email) {
+		msgs = append(msgs, "The password must not contain your email address")
+	}
+
+	breached, err := p.isBreached(password)
+	if err != nil {
+		return nil, err
+	}
+	if breached {
+		msgs = append(msgs, "This password has appeared in a data breach, choose a different one")
+	}
+
+	return msgs, nil
+}
+
+// containsEmail also catches the part before the @, which is what people
+// usually reuse. Very short local parts are ignored to avoid false matches.
+func containsEmail(password string, email string) bool {
+	password = strings.ToLower(password)
+	email = strings.ToLower(email)
+	if email == "" {
+		return false
+	}
+
+	localPart, _, _ := strings.Cut(email, "@")
+	return strings.Contains(password, email) || (len(localPart) >= 3 && strings.Contains(password, localPart))
+}
+
+func (p *PasswordPolicy) isBreached(password string) (bool, error) {
+	if p.BreachedDir == "" {
+		return false, nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+
+	file, err := os.Open(filepath.Join(p.BreachedDir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		// a partial list simply has no entries for this prefix
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	minCount := p.Cnf.Env.GetInt("PASSWORD_BREACHED_MIN_COUNT")
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !strings.EqualFold(lineSuffix, suffix) {
+			continue
+		}
+
+		occurrences, err := strconv.Atoi(count)
+		if err != nil {
+			// lists without counts only hold breached passwords
+			return true, nil
+		}
+		return occurrences >= minCount, nil
+	}
+
+	return false, scanner.Err()
+}
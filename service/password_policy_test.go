@@ -0,0 +1,166 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newTestPasswordPolicy(t *testing.T, values map[string]any) *PasswordPolicy {
+	t.Helper()
+
+	env := viper.New()
+	env.Set("PASSWORD_MIN_LENGTH", 8)
+	env.Set("PASSWORD_REQUIRE_LOWERCASE", true)
+	env.Set("PASSWORD_REQUIRE_UPPERCASE", true)
+	env.Set("PASSWORD_REQUIRE_DIGIT", true)
+	env.Set("PASSWORD_REQUIRE_SYMBOL", true)
+	env.Set("PASSWORD_DISALLOW_EMAIL", true)
+	env.Set("PASSWORD_BREACHED_MIN_COUNT", 1)
+	for key, value := range values {
+		env.Set(key, value)
+	}
+
+	return NewPasswordPolicy(&config.Config{Env: env})
+}
+
+// writeBreached stores the password in a breached list laid out like the
+// downloaded one and returns the directory.
+func writeBreached(t *testing.T, password string, count string) string {
+	t.Helper()
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	dir := t.TempDir()
+	line := hash[breachedPrefixLength:]
+	if count != "" {
+		line += ":" + count
+	}
+	err := os.WriteFile(filepath.Join(dir, hash[:breachedPrefixLength]+".txt"), []byte("0000000000000000000000000000000000A:3\n"+line+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := newTestPasswordPolicy(t, nil)
+
+	tests := []struct {
+		name     string
+		password string
+		email    string
+		want     []string
+	}{
+		{name: "acceptable", password: "Tr0ub4dor&3"},
+		{
+			name:     "every rule broken",
+			password: "jane",
+			email:    "jane@example.com",
+			want: []string{
+				"The password must be at least 8 characters",
+				"The password must contain an uppercase letter",
+				"The password must contain a number",
+				"The password must contain a symbol",
+				"The password must not contain your email address",
+			},
+		},
+		{
+			name:     "missing classes only",
+			password: "ABCDEFGHIJ",
+			want: []string{
+				"The password must contain a lowercase letter",
+				"The password must contain a number",
+				"The password must contain a symbol",
+			},
+		},
+		{name: "too short", password: "Ab1!", want: []string{"The password must be at least 8 characters"}},
+		{name: "counts characters not bytes", password: "Äb1!äöüß"},
+		{name: "contains the email", password: "Jane.Doe@Example.com1", email: "jane.doe@example.com", want: []string{"The password must not contain your email address"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msgs, err := policy.Check(test.password, test.email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(msgs, test.want) {
+				t.Fatalf("Check() = %q, want %q", msgs, test.want)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyCheckDisabledRules(t *testing.T) {
+	policy := newTestPasswordPolicy(t, map[string]any{
+		"PASSWORD_MIN_LENGTH":        1,
+		"PASSWORD_REQUIRE_LOWERCASE": false,
+		"PASSWORD_REQUIRE_UPPERCASE": false,
+		"PASSWORD_REQUIRE_DIGIT":     false,
+		"PASSWORD_REQUIRE_SYMBOL":    false,
+		"PASSWORD_DISALLOW_EMAIL":    false,
+	})
+
+	msgs, err := policy.Check("jane", "jane@example.com")
+	if err != nil || len(msgs) != 0 {
+		t.Fatalf("Check() = %q, %v", msgs, err)
+	}
+}
+
+func TestPasswordPolicyCheckBreached(t *testing.T) {
+	const password = "Summer2024!"
+	const breachedMsg = "This password has appeared in a data breach, choose a different one"
+
+	tests := []struct {
+		name     string
+		count    string
+		minCount int
+		want     []string
+	}{
+		{name: "listed", count: "12", minCount: 1, want: []string{breachedMsg}},
+		{name: "below the minimum count", count: "2", minCount: 10},
+		{name: "list without counts", count: "", minCount: 10, want: []string{breachedMsg}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := newTestPasswordPolicy(t, map[string]any{
+				"PASSWORD_BREACHED_DIR":       writeBreached(t, password, test.count),
+				"PASSWORD_BREACHED_MIN_COUNT": test.minCount,
+			})
+
+			msgs, err := policy.Check(password, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(msgs, test.want) {
+				t.Fatalf("Check() = %q, want %q", msgs, test.want)
+			}
+
+			// other passwords are not flagged, even without a file for their prefix
+			msgs, err = policy.Check("Winter2024!", "")
+			if err != nil || len(msgs) != 0 {
+				t.Fatalf("Check() = %q, %v", msgs, err)
+			}
+		})
+	}
+
+	// a short password on the list reports both problems
+	policy := newTestPasswordPolicy(t, map[string]any{"PASSWORD_BREACHED_DIR": writeBreached(t, "Ab1!", "5")})
+	msgs, err := policy.Check("Ab1!", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"The password must be at least 8 characters", breachedMsg}; !slices.Equal(msgs, want) {
+		t.Fatalf("Check() = %q, want %q", msgs, want)
+	}
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/helpers"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"strings"
+	"time"
+)
+
+const dateOfBirthLayout = "2006-01-02"
+
+type ProfileService interface {
+	Get(ctx context.Context, user *model.User) (*model.ProfileResponse, error)
+	Update(ctx context.Context, user *model.User, req model.UpdateProfileRequest) (*model.ProfileResponse, error)
+	Delete(ctx context.Context, user *model.User) error
+}
+
+type ProfileServiceImpl struct {
+	UserProfileRepo repository.UserProfileRepository
+	DB              *sql.DB
+	Validate        *validator.Validate
+}
+
+func NewProfileService(userProfileRepo repository.UserProfileRepository, DB *sql.DB, validate *validator.Validate) *ProfileServiceImpl {
+	return &ProfileServiceImpl{UserProfileRepo: userProfileRepo, DB: DB, Validate: validate}
+}
+
+func toProfileResponse(profile *model.UserProfile) *model.ProfileResponse {
+	resp := &model.ProfileResponse{
+		DisplayName:        profile.DisplayName,
+		AvatarUrl:          profile.AvatarUrl,
+		Sex:                profile.Sex,
+		Allergies:          helpers.NonNilStrings(profile.Allergies),
+		ChronicConditions:  helpers.NonNilStrings(profile.ChronicConditions),
+		CurrentMedications: helpers.NonNilStrings(profile.CurrentMedications),
+	}
+
+	if profile.DateOfBirth != nil {
+		resp.DateOfBirth = profile.DateOfBirth.Format(dateOfBirthLayout)
+	}
+
+	return resp
+}
+
+func (p ProfileServiceImpl) Get(ctx context.Context, user *model.User) (*model.ProfileResponse, error) {
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	profile, err := p.UserProfileRepo.FindByUserId(ctx, tx, user.Id)
+	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
+		return toProfileResponse(&model.UserProfile{}), nil
+	} else if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	return toProfileResponse(profile), nil
+}
+
+func (p ProfileServiceImpl) Update(ctx context.Context, user *model.User, req model.UpdateProfileRequest) (*model.ProfileResponse, error) {
+	err := p.Validate.Struct(req)
+	if err != nil {
+		return nil, exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+	}
+
+	var dateOfBirth *time.Time
+	if req.DateOfBirth != "" {
+		parsed, _ := time.Parse(dateOfBirthLayout, req.DateOfBirth)
+		if parsed.After(time.Now()) {
+			return nil, exceptions.NewBadRequestError("Date of birth cannot be in the future")
+		}
+		dateOfBirth = &parsed
+	}
+
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	now := time.Now()
+	profile, err := p.UserProfileRepo.Save(ctx, tx, &model.UserProfile{
+		UserId:             user.Id,
+		DisplayName:        strings.TrimSpace(req.DisplayName),
+		AvatarUrl:          req.AvatarUrl,
+		DateOfBirth:        dateOfBirth,
+		Sex:                req.Sex,
+		Allergies:          trimAll(req.Allergies),
+		ChronicConditions:  trimAll(req.ChronicConditions),
+		CurrentMedications: trimAll(req.CurrentMedications),
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	return toProfileResponse(profile), nil
+}
+
+func (p ProfileServiceImpl) Delete(ctx context.Context, user *model.User) error {
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	err = p.UserProfileRepo.DeleteByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_ = tx.Commit()
+
+	return nil
+}
+
+func trimAll(values []string) []string {
+	trimmed := make([]string, 0, len(values))
+	for _, value := range values {
+		trimmed = append(trimmed, strings.TrimSpace(value))
+	}
+	return trimmed
+}
+
+// profilePromptContext describes the patient in plain sentences so the model
+// can tailor its advice. Only clinically relevant fields are shared; the
+// display name and avatar never leave the service.
+func profilePromptContext(profile *model.UserProfile, now time.Time) string {
+	var lines []string
+
+	if profile.DateOfBirth != nil {
+		lines = append(lines, "Age: "+describeAge(*profile.DateOfBirth, now))
+	}
+	if profile.Sex != "" {
+		lines = append(lines, "Sex: "+profile.Sex)
+	}
+	if len(profile.Allergies) > 0 {
+		lines = append(lines, "Known allergies: "+strings.Join(profile.Allergies, ", "))
+	}
+	if len(profile.ChronicConditions) > 0 {
+		lines = append(lines, "Chronic conditions: "+strings.Join(profile.ChronicConditions, ", "))
+	}
+	if len(profile.CurrentMedications) > 0 {
+		lines = append(lines, "Current medications: "+strings.Join(profile.CurrentMedications, ", "))
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return "Patient context, take it into account when assessing the wound and recommending treatment or drugs:\n" +
+		strings.Join(lines, "\n")
+}
+
+// describeAge uses months for children under two, where dosing and advice
+// change quickly.
+func describeAge(dateOfBirth time.Time, now time.Time) string {
+	months := (now.Year()-dateOfBirth.Year())*12 + int(now.Month()) - int(dateOfBirth.Month())
+	if now.Day() < dateOfBirth.Day() {
+		months--
+	}
+	if months < 0 {
+		months = 0
+	}
+
+	if months < 24 {
+		return fmt.Sprintf("%d months", months)
+	}
+
+	return fmt.Sprintf("%d years", months/12)
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/helpers"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"strings"
+)
+
+type RoleService interface {
+	GetRoles(ctx context.Context) (*[]model.RoleResponse, error)
+	GetUserRoles(ctx context.Context, userId int) (*model.UserRolesResponse, error)
+	AssignRole(ctx context.Context, admin *model.User, userId int, req model.AssignRoleRequest, client model.ClientInfo) (*model.UserRolesResponse, error)
+	RevokeRole(ctx context.Context, admin *model.User, userId int, role string, client model.ClientInfo) (*model.UserRolesResponse, error)
+	BootstrapAdmin(ctx context.Context, email string) error
+}
+
+type RoleServiceImpl struct {
+	RoleRepo     repository.RoleRepository
+	UserRepo     repository.UserRepository
+	DB           *sql.DB
+	Validate     *validator.Validate
+	SessionCache *SessionCache
+	AuditLog     *AuditLog
+}
+
+func NewRoleService(roleRepo repository.RoleRepository, userRepo repository.UserRepository, DB *sql.DB, validate *validator.Validate, sessionCache *SessionCache, auditLog *AuditLog) *RoleServiceImpl {
+	return &RoleServiceImpl{RoleRepo: roleRepo, UserRepo: userRepo, DB: DB, Validate: validate, SessionCache: sessionCache, AuditLog: auditLog}
+}
+
+func (r RoleServiceImpl) GetRoles(ctx context.Context) (*[]model.RoleResponse, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	roles, err := r.RoleRepo.FindAll(ctx, tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	roleResponses := make([]model.RoleResponse, 0, len(roles))
+	for _, role := range roles {
+		roleResponses = append(roleResponses, model.RoleResponse{
+			Name:        role.Name,
+			Description: role.Description,
+			Permissions: role.Permissions,
+		})
+	}
+
+	return &roleResponses, nil
+}
+
+func (r RoleServiceImpl) GetUserRoles(ctx context.Context, userId int) (*model.UserRolesResponse, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	resp, err := r.userRoles(ctx, tx, userId)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	return resp, nil
+}
+
+func (r RoleServiceImpl) AssignRole(ctx context.Context, admin *model.User, userId int, req model.AssignRoleRequest, client model.ClientInfo) (*model.UserRolesResponse, error) {
+	err := r.Validate.Struct(req)
+	if err != nil {
+		return nil, exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+	}
+
+	role := strings.ToLower(strings.TrimSpace(req.Role))
+	if role == model.RoleUser {
+		return nil, exceptions.NewBadRequestError("Every user already holds the user role")
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	resp, err := r.userRoles(ctx, tx, userId)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	assigned, err := r.RoleRepo.AssignRole(ctx, tx, userId, role)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if !assigned {
+		_ = tx.Rollback()
+		for _, held := range resp.Roles {
+			if held == role {
+				return nil, exceptions.NewHttpConflictError("User already holds this role")
+			}
+		}
+		return nil, exceptions.NewHttpNotFoundError("Role not found")
+	}
+
+	resp, err = r.userRoles(ctx, tx, userId)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	r.AuditLog.Record(ctx, model.AuditRoleAssigned, model.AuditSuccess, admin, "", client, fmt.Sprintf("role %s to user %d", role, userId))
+
+	err = r.SessionCache.Forget(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (r RoleServiceImpl) RevokeRole(ctx context.Context, admin *model.User, userId int, role string, client model.ClientInfo) (*model.UserRolesResponse, error) {
+	role = strings.ToLower(role)
+	if role == model.RoleUser {
+		return nil, exceptions.NewBadRequestError("The user role cannot be revoked")
+	}
+
+	// An admin locking themselves out would leave nobody able to undo it.
+	if admin.Id == userId && role == model.RoleAdmin {
+		return nil, exceptions.NewForbiddenError("You cannot revoke your own admin role")
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	_, err = r.userRoles(ctx, tx, userId)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	revoked, err := r.RoleRepo.RevokeRole(ctx, tx, userId, role)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if !revoked {
+		_ = tx.Rollback()
+		return nil, exceptions.NewHttpNotFoundError("User does not hold this role")
+	}
+
+	resp, err := r.userRoles(ctx, tx, userId)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	r.AuditLog.Record(ctx, model.AuditRoleRevoked, model.AuditSuccess, admin, "", client, fmt.Sprintf("role %s from user %d", role, userId))
+
+	err = r.SessionCache.Forget(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// BootstrapAdmin makes the user with the email the first admin. It does
+// nothing once anyone holds the admin role, so leaving ADMIN_BOOTSTRAP_EMAIL
+// set cannot hand out the role later. The email must be verified, or whoever
+// registered it first could claim the role.
+func (r RoleServiceImpl) BootstrapAdmin(ctx context.Context, email string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	admins, err := r.RoleRepo.CountUsersByRole(ctx, tx, model.RoleAdmin)
+	if err != nil || admins > 0 {
+		_ = tx.Rollback()
+		return err
+	}
+
+	user, err := r.UserRepo.FindByEmail(ctx, tx, email)
+	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+		_ = tx.Rollback()
+		return fmt.Errorf("no user with the email %s, register it first", email)
+	} else if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if user.EmailVerifiedAt == nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("the email %s is not verified yet", email)
+	}
+
+	assigned, err := r.RoleRepo.AssignRole(ctx, tx, user.Id, model.RoleAdmin)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_ = tx.Commit()
+
+	if assigned {
+		r.AuditLog.Record(ctx, model.AuditRoleAssigned, model.AuditSuccess, user, "", model.ClientInfo{}, fmt.Sprintf("role %s to user %d by ADMIN_BOOTSTRAP_EMAIL", model.RoleAdmin, user.Id))
+	}
+
+	return r.SessionCache.Forget(ctx, user.Id)
+}
+
+func (r RoleServiceImpl) userRoles(ctx context.Context, tx *sql.Tx, userId int) (*model.UserRolesResponse, error) {
+	_, err := r.UserRepo.FindById(ctx, tx, userId)
+	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+		return nil, exceptions.NewHttpNotFoundError("User not found")
+	} else if err != nil {
+		return nil, err
+	}
+
+	roles, err := r.RoleRepo.FindRoleNamesByUserId(ctx, tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := r.RoleRepo.FindPermissionNamesByUserId(ctx, tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.UserRolesResponse{
+		UserId:      userId,
+		Roles:       append([]string{model.RoleUser}, roles...),
+		Permissions: helpers.NonNilStrings(permissions),
+	}, nil
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/helpers"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"time"
+)
+
+// SessionCache keeps what the Authenticate middleware loads for an access
+// token (the session and its user with roles) in redis, so most requests do
+// not touch the database. Entries live until the access token expires, capped
+// by SESSION_CACHE_TTL. Credentials are never cached: users read from the
+// cache have no password hash or TOTP secret, and services needing them load
+// the user again.
+//
+// Every entry is stamped with a per user version. Forget replaces the
+// version, which drops all cached sessions of the user at once; it has to be
+// called after any change to the user, their roles or their sessions is
+// committed.
+type SessionCache struct {
+	RedisClient *redis.Client
+	Cnf         *config.Config
+}
+
+func NewSessionCache(redisClient *redis.Client, cnf *config.Config) *SessionCache {
+	return &SessionCache{RedisClient: redisClient, Cnf: cnf}
+}
+
+type cachedSession struct {
+	Version               int64      `json:"version"`
+	SessionId             int        `json:"session_id"`
+	ExpiresAt             time.Time  `json:"expires_at"`
+	AccessExpiresAt       time.Time  `json:"access_expires_at"`
+	RevokedAt             *time.Time `json:"revoked_at"`
+	CreatedAt             time.Time  `json:"created_at"`
+	LastSeenAt            time.Time  `json:"last_seen_at"`
+	ImpersonatorId        *int       `json:"impersonator_id"`
+	ImpersonationReadOnly bool       `json:"impersonation_read_only"`
+	UserId                int        `json:"user_id"`
+	Email                 string     `json:"email"`
+	EmailVerifiedAt       *time.Time `json:"email_verified_at"`
+	TotpEnabledAt         *time.Time `json:"totp_enabled_at"`
+	Roles                 []string   `json:"roles"`
+	Permissions           []string   `json:"permissions"`
+}
+
+func sessionCacheKey(token string) string {
+	return "session_cache:" + helpers.HashToken(token)
+}
+
+func sessionCacheVersionKey(userId int) string {
+	return fmt.Sprintf("session_cache_version:%d", userId)
+}
+
+func (s *SessionCache) enabled() bool {
+	return s.Cnf.Env.GetDuration("SESSION_CACHE_TTL") > 0
+}
+
+// Get returns the cached session for the token. A miss, a stale entry or a
+// redis error all report false so the caller falls back to the database.
+func (s *SessionCache) Get(ctx context.Context, token string) (*model.Session, *model.User, int64, bool) {
+	if !s.enabled() {
+		return nil, nil, 0, false
+	}
+
+	raw, err := s.RedisClient.Get(ctx, sessionCacheKey(token)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Println("error while get session from cache", err)
+		}
+		return nil, nil, 0, false
+	}
+
+	var entry cachedSession
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return nil, nil, 0, false
+	}
+
+	version, err := s.Version(ctx, entry.UserId)
+	if err != nil || version != entry.Version {
+		return nil, nil, 0, false
+	}
+
+	session := &model.Session{
+		Id:                    entry.SessionId,
+		UserId:                entry.UserId,
+		Token:                 token,
+		ExpiresAt:             entry.ExpiresAt,
+		AccessExpiresAt:       entry.AccessExpiresAt,
+		RevokedAt:             entry.RevokedAt,
+		CreatedAt:             entry.CreatedAt,
+		LastSeenAt:            entry.LastSeenAt,
+		ImpersonatorId:        entry.ImpersonatorId,
+		ImpersonationReadOnly: entry.ImpersonationReadOnly,
+	}
+	user := &model.User{
+		Id:              entry.UserId,
+		Email:           entry.Email,
+		EmailVerifiedAt: entry.EmailVerifiedAt,
+		TotpEnabledAt:   entry.TotpEnabledAt,
+		Roles:           entry.Roles,
+		Permissions:     entry.Permissions,
+	}
+
+	return session, user, entry.Version, true
+}
+
+// Version must be read before the rows passed to Set are loaded from the
+// database, so a Forget in between keeps the loaded copy out of use.
+func (s *SessionCache) Version(ctx context.Context, userId int) (int64, error) {
+	version, err := s.RedisClient.Get(ctx, sessionCacheVersionKey(userId)).Int64()
+	if err != nil && errors.Is(err, redis.Nil) {
+		return 0, nil
+	} else if err != nil {
+		log.Println("error while get session cache version", err)
+		return 0, err
+	}
+
+	return version, nil
+}
+
+func (s *SessionCache) Set(ctx context.Context, token string, version int64, session *model.Session, user *model.User) {
+	if !s.enabled() {
+		return
+	}
+
+	ttl := min(time.Until(session.AccessExpiresAt), s.Cnf.Env.GetDuration("SESSION_CACHE_TTL"))
+	if ttl <= 0 {
+		return
+	}
+
+	raw, err := json.Marshal(cachedSession{
+		Version:               version,
+		SessionId:             session.Id,
+		ExpiresAt:             session.ExpiresAt,
+		AccessExpiresAt:       session.AccessExpiresAt,
+		RevokedAt:             session.RevokedAt,
+		CreatedAt:             session.CreatedAt,
+		LastSeenAt:            session.LastSeenAt,
+		ImpersonatorId:        session.ImpersonatorId,
+		ImpersonationReadOnly: session.ImpersonationReadOnly,
+		UserId:                user.Id,
+		Email:                 user.Email,
+		EmailVerifiedAt:       user.EmailVerifiedAt,
+		TotpEnabledAt:         user.TotpEnabledAt,
+		Roles:                 user.Roles,
+		Permissions:           user.Permissions,
+	})
+	if err != nil {
+		return
+	}
+
+	err = s.RedisClient.Set(ctx, sessionCacheKey(token), raw, ttl).Err()
+	if err != nil {
+		log.Println("error while set session to cache", err)
+	}
+}
+
+// Forget invalidates every cached session of the user by giving them a new
+// version. Versions are timestamps rather than counters, so once the key
+// expires a later version can never match an old entry again.
+//
+// When it fails the cached sessions stay usable until they expire, so callers
+// revoking access must report the error instead of claiming success.
+func (s *SessionCache) Forget(ctx context.Context, userId int) error {
+	if !s.enabled() {
+		return nil
+	}
+
+	err := s.RedisClient.Set(ctx, sessionCacheVersionKey(userId), time.Now().UnixNano(), s.Cnf.Env.GetDuration("SESSION_CACHE_TTL")).Err()
+	if err != nil {
+		log.Println("error while forget cached sessions", err)
+		return exceptions.NewInternalServerError()
+	}
+
+	return nil
+}
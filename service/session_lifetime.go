@@ -0,0 +1,67 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"time"
+)
+
+// SessionLifetime decides how long a session stays usable. A session in use
+// keeps sliding forward by REFRESH_TOKEN_TTL, but never past
+// SESSION_MAX_LIFETIME after sign in, and it ends early once nothing used it
+// for SESSION_IDLE_TIMEOUT. Either limit is turned off by setting it to 0.
+type SessionLifetime struct {
+	Cnf *config.Config
+}
+
+func NewSessionLifetime(cnf *config.Config) *SessionLifetime {
+	return &SessionLifetime{Cnf: cnf}
+}
+
+// Cap clamps an expiry so it does not outlive the maximum session lifetime.
+func (l *SessionLifetime) Cap(session *model.Session, expiresAt time.Time) time.Time {
+	maxLifetime := l.Cnf.Env.GetDuration("SESSION_MAX_LIFETIME")
+	if maxLifetime <= 0 {
+		return expiresAt
+	}
+
+	if limit := session.CreatedAt.Add(maxLifetime); expiresAt.After(limit) {
+		return limit
+	}
+
+	return expiresAt
+}
+
+// Active reports whether the session may still be used. It does not look at
+// the access token expiry, which callers holding an access token check too.
+func (l *SessionLifetime) Active(session *model.Session, now time.Time) bool {
+	if session.RevokedAt != nil || !session.ExpiresAt.After(now) {
+		return false
+	}
+
+	idleTimeout := l.Cnf.Env.GetDuration("SESSION_IDLE_TIMEOUT")
+	if idleTimeout > 0 && now.Sub(session.LastSeenAt) > idleTimeout {
+		return false
+	}
+
+	maxLifetime := l.Cnf.Env.GetDuration("SESSION_MAX_LIFETIME")
+	return maxLifetime <= 0 || now.Sub(session.CreatedAt) <= maxLifetime
+}
+
+// Renew slides the session expiry forward for activity at now. It reports
+// false, leaving the session as is, when the expiry would move by less than
+// SESSION_RENEW_INTERVAL, so busy sessions are not rewritten on every request.
+func (l *SessionLifetime) Renew(session *model.Session, now time.Time) bool {
+	// impersonation sessions end when they were set to
+	if session.ImpersonatorId != nil {
+		return false
+	}
+
+	expiresAt := l.Cap(session, now.Add(l.Cnf.Env.GetDuration("REFRESH_TOKEN_TTL")))
+	if expiresAt.Sub(session.ExpiresAt) < l.Cnf.Env.GetDuration("SESSION_RENEW_INTERVAL") {
+		return false
+	}
+
+	session.ExpiresAt = expiresAt
+	return true
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"github.com/spf13/viper"
+	"testing"
+	"time"
+)
+
+func newTestSessionLifetime(idleTimeout string, maxLifetime string) *SessionLifetime {
+	env := viper.New()
+	env.Set("REFRESH_TOKEN_TTL", "168h")
+	env.Set("SESSION_RENEW_INTERVAL", "1h")
+	env.Set("SESSION_IDLE_TIMEOUT", idleTimeout)
+	env.Set("SESSION_MAX_LIFETIME", maxLifetime)
+
+	return NewSessionLifetime(&config.Config{Env: env})
+}
+
+func TestSessionLifetimeCap(t *testing.T) {
+	createdAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	session := &model.Session{CreatedAt: createdAt}
+
+	lifetime := newTestSessionLifetime("72h", "720h")
+	limit := createdAt.Add(720 * time.Hour)
+
+	if got := lifetime.Cap(session, createdAt.Add(time.Hour)); !got.Equal(createdAt.Add(time.Hour)) {
+		t.Fatalf("Cap() within the lifetime = %v", got)
+	}
+	if got := lifetime.Cap(session, limit.Add(time.Hour)); !got.Equal(limit) {
+		t.Fatalf("Cap() past the lifetime = %v, want %v", got, limit)
+	}
+
+	unlimited := newTestSessionLifetime("72h", "0")
+	if got := unlimited.Cap(session, limit.Add(time.Hour)); !got.Equal(limit.Add(time.Hour)) {
+		t.Fatalf("Cap() without a max lifetime = %v", got)
+	}
+}
+
+func TestSessionLifetimeActive(t *testing.T) {
+	now := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	revokedAt := now.Add(-time.Minute)
+
+	tests := []struct {
+		name        string
+		idleTimeout string
+		maxLifetime string
+		session     model.Session
+		want        bool
+	}{
+		{
+			name:        "in use",
+			idleTimeout: "72h", maxLifetime: "720h",
+			session: model.Session{CreatedAt: now.Add(-24 * time.Hour), LastSeenAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+			want:    true,
+		},
+		{
+			name:        "revoked",
+			idleTimeout: "72h", maxLifetime: "720h",
+			session: model.Session{CreatedAt: now.Add(-24 * time.Hour), LastSeenAt: now, ExpiresAt: now.Add(time.Hour), RevokedAt: &revokedAt},
+		},
+		{
+			name:        "expired",
+			idleTimeout: "72h", maxLifetime: "720h",
+			session: model.Session{CreatedAt: now.Add(-24 * time.Hour), LastSeenAt: now, ExpiresAt: now},
+		},
+		{
+			name:        "idle",
+			idleTimeout: "72h", maxLifetime: "720h",
+			session: model.Session{CreatedAt: now.Add(-96 * time.Hour), LastSeenAt: now.Add(-73 * time.Hour), ExpiresAt: now.Add(time.Hour)},
+		},
+		{
+			name:        "idle timeout off",
+			idleTimeout: "0", maxLifetime: "720h",
+			session: model.Session{CreatedAt: now.Add(-96 * time.Hour), LastSeenAt: now.Add(-73 * time.Hour), ExpiresAt: now.Add(time.Hour)},
+			want:    true,
+		},
+		{
+			name:        "past the max lifetime",
+			idleTimeout: "72h", maxLifetime: "720h",
+			session: model.Session{CreatedAt: now.Add(-721 * time.Hour), LastSeenAt: now, ExpiresAt: now.Add(time.Hour)},
+		},
+		{
+			name:        "max lifetime off",
+			idleTimeout: "72h", maxLifetime: "0",
+			session: model.Session{CreatedAt: now.Add(-721 * time.Hour), LastSeenAt: now, ExpiresAt: now.Add(time.Hour)},
+			want:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lifetime := newTestSessionLifetime(test.idleTimeout, test.maxLifetime)
+			if got := lifetime.Active(&test.session, now); got != test.want {
+				t.Fatalf("Active() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSessionLifetimeRenew(t *testing.T) {
+	now := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	lifetime := newTestSessionLifetime("72h", "720h")
+
+	t.Run("slides forward", func(t *testing.T) {
+		session := &model.Session{CreatedAt: now.Add(-24 * time.Hour), ExpiresAt: now.Add(time.Hour)}
+		if !lifetime.Renew(session, now) || !session.ExpiresAt.Equal(now.Add(168*time.Hour)) {
+			t.Fatalf("Renew() expiry = %v", session.ExpiresAt)
+		}
+	})
+
+	t.Run("within the renew interval", func(t *testing.T) {
+		expiresAt := now.Add(168*time.Hour - 30*time.Minute)
+		session := &model.Session{CreatedAt: now.Add(-24 * time.Hour), ExpiresAt: expiresAt}
+		if lifetime.Renew(session, now) || !session.ExpiresAt.Equal(expiresAt) {
+			t.Fatalf("Renew() rewrote the session, expiry = %v", session.ExpiresAt)
+		}
+	})
+
+	t.Run("capped by the max lifetime", func(t *testing.T) {
+		createdAt := now.Add(-700 * time.Hour)
+		session := &model.Session{CreatedAt: createdAt, ExpiresAt: now.Add(time.Hour)}
+		if !lifetime.Renew(session, now) || !session.ExpiresAt.Equal(createdAt.Add(720*time.Hour)) {
+			t.Fatalf("Renew() expiry = %v, want %v", session.ExpiresAt, createdAt.Add(720*time.Hour))
+		}
+
+		// at the cap there is nothing left to renew
+		if lifetime.Renew(session, now.Add(2*time.Hour)) {
+			t.Fatal("Renew() moved a session past its max lifetime")
+		}
+	})
+
+	t.Run("impersonation", func(t *testing.T) {
+		impersonatorId := 1
+		expiresAt := now.Add(30 * time.Minute)
+		session := &model.Session{CreatedAt: now, ExpiresAt: expiresAt, ImpersonatorId: &impersonatorId}
+		if lifetime.Renew(session, now) || !session.ExpiresAt.Equal(expiresAt) {
+			t.Fatal("Renew() extended an impersonation session")
+		}
+	})
+}
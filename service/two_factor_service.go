@@ -0,0 +1,368 @@
+package service
+
+import (
+	"akmmp241/dinamcom-2024/dinacom-go-rest/config"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/exceptions"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/helpers"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/model"
+	"akmmp241/dinamcom-2024/dinacom-go-rest/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	recoveryCodeCount    = 10
+	twoFactorMaxAttempts = 5
+)
+
+type TwoFactorService interface {
+	Enroll(ctx context.Context, user *model.User, session *model.Session, req model.TwoFactorEnrollRequest) (*model.TwoFactorEnrollResponse, error)
+	Confirm(ctx context.Context, user *model.User, req model.TwoFactorCodeRequest, client model.ClientInfo) (*model.TwoFactorConfirmResponse, error)
+	Disable(ctx context.Context, user *model.User, session *model.Session, req model.TwoFactorDisableRequest, client model.ClientInfo) error
+	SealStoredSecrets(ctx context.Context) error
+}
+
+type TwoFactorServiceImpl struct {
+	UserRepo         repository.UserRepository
+	RecoveryCodeRepo repository.RecoveryCodeRepository
+	DB               *sql.DB
+	Validate         *validator.Validate
+	Cnf              *config.Config
+	Keyring          *config.Keyring
+	RedisClient      *redis.Client
+	SessionCache     *SessionCache
+	AuditLog         *AuditLog
+	PasswordHasher   helpers.PasswordHasher
+}
+
+func NewTwoFactorService(
+	userRepo repository.UserRepository,
+	recoveryCodeRepo repository.RecoveryCodeRepository,
+	DB *sql.DB,
+	validate *validator.Validate,
+	cnf *config.Config,
+	keyring *config.Keyring,
+	redisClient *redis.Client,
+	sessionCache *SessionCache,
+	auditLog *AuditLog,
+	passwordHasher helpers.PasswordHasher,
+) *TwoFactorServiceImpl {
+	return &TwoFactorServiceImpl{UserRepo: userRepo, RecoveryCodeRepo: recoveryCodeRepo, DB: DB, Validate: validate, Cnf: cnf, Keyring: keyring, RedisClient: redisClient, SessionCache: sessionCache, AuditLog: auditLog, PasswordHasher: passwordHasher}
+}
+
+func (t TwoFactorServiceImpl) Enroll(ctx context.Context, user *model.User, session *model.Session, req model.TwoFactorEnrollRequest) (*model.TwoFactorEnrollResponse, error) {
+	if user.TotpEnabledAt != nil {
+		return nil, exceptions.NewBadRequestError("Two-factor authentication already enabled")
+	}
+
+	secret, err := helpers.GenerateTotpSecret()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	sealedSecret, err := helpers.SealSecret(t.Keyring, secret, totpSecretBinding(user.Id))
+	if err != nil {
+		log.Println("error while seal totp secret", err)
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	tx, err := t.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	// the user from the request context carries no credentials
+	user, err = t.UserRepo.FindById(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	err = t.reauthenticate(user, session, req.Password)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	// the secret stays pending until the user proves their authenticator
+	// produces valid codes in Confirm
+	err = t.UserRepo.UpdateTotp(ctx, tx, user.Id, sealedSecret, nil)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	_ = t.SessionCache.Forget(ctx, user.Id)
+
+	return &model.TwoFactorEnrollResponse{
+		Secret:     secret,
+		OtpauthUri: helpers.TotpUri(t.Cnf.Env.GetString("TOTP_ISSUER"), user.Email, secret),
+	}, nil
+}
+
+func (t TwoFactorServiceImpl) Confirm(ctx context.Context, user *model.User, req model.TwoFactorCodeRequest, client model.ClientInfo) (*model.TwoFactorConfirmResponse, error) {
+	err := t.Validate.Struct(req)
+	if err != nil {
+		return nil, exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+	}
+
+	if user.TotpEnabledAt != nil {
+		return nil, exceptions.NewBadRequestError("Two-factor authentication already enabled")
+	}
+
+	tx, err := t.DB.Begin()
+	if err != nil {
+		return nil, exceptions.NewInternalServerError()
+	}
+
+	// the user from the request context carries no TOTP secret
+	user, err = t.UserRepo.FindById(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if user.TotpSecret == "" {
+		_ = tx.Rollback()
+		return nil, exceptions.NewBadRequestError("Two-factor authentication is not enrolled")
+	}
+
+	secret, err := openTotpSecret(t.Keyring, user)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if _, ok := helpers.VerifyTotp(secret, req.Code, time.Now()); !ok {
+		_ = tx.Rollback()
+		return nil, exceptions.NewBadRequestError("Invalid two-factor code")
+	}
+
+	now := time.Now()
+	err = t.UserRepo.UpdateTotp(ctx, tx, user.Id, user.TotpSecret, &now)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	recoveryCodes, err := generateRecoveryCodes(ctx, tx, t.RecoveryCodeRepo, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_ = tx.Commit()
+
+	t.AuditLog.Record(ctx, model.AuditTwoFactorEnabled, model.AuditSuccess, user, "", client, "")
+
+	_ = t.SessionCache.Forget(ctx, user.Id)
+
+	return &model.TwoFactorConfirmResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (t TwoFactorServiceImpl) Disable(ctx context.Context, user *model.User, session *model.Session, req model.TwoFactorDisableRequest, client model.ClientInfo) error {
+	err := t.Validate.Struct(req)
+	if err != nil {
+		return exceptions.NewFailedValidationError(req, err.(validator.ValidationErrors))
+	}
+
+	if user.TotpEnabledAt == nil {
+		return exceptions.NewBadRequestError("Two-factor authentication is not enabled")
+	}
+
+	tx, err := t.DB.Begin()
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	// the user from the request context carries no credentials
+	user, err = t.UserRepo.FindById(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	err = t.reauthenticate(user, session, req.Password)
+	if err != nil {
+		_ = tx.Rollback()
+		t.AuditLog.Record(ctx, model.AuditTwoFactorDisabled, model.AuditFailure, user, "", client, "re-authentication failed")
+		return err
+	}
+
+	ok, err := verifySecondFactor(ctx, tx, t.RedisClient, t.Keyring, t.RecoveryCodeRepo, user, req.Code)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if !ok {
+		_ = tx.Rollback()
+		t.AuditLog.Record(ctx, model.AuditTwoFactorDisabled, model.AuditFailure, user, "", client, "invalid two-factor code")
+		return exceptions.NewBadRequestError("Invalid two-factor code")
+	}
+
+	err = t.UserRepo.UpdateTotp(ctx, tx, user.Id, "", nil)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	err = t.RecoveryCodeRepo.DeleteAllByUserId(ctx, tx, user.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_ = tx.Commit()
+
+	t.AuditLog.Record(ctx, model.AuditTwoFactorDisabled, model.AuditSuccess, user, "", client, "")
+
+	err = t.SessionCache.Forget(ctx, user.Id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SealStoredSecrets encrypts the TOTP secrets stored in plaintext before
+// encryption was added and re-encrypts those sealed with a previous key, so
+// previous keys can be dropped once it has run.
+func (t TwoFactorServiceImpl) SealStoredSecrets(ctx context.Context) error {
+	tx, err := t.DB.Begin()
+	if err != nil {
+		return exceptions.NewInternalServerError()
+	}
+
+	users, err := t.UserRepo.FindAllWithTotpSecretOutside(ctx, tx, helpers.CurrentSecretPrefix(t.Keyring))
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	for _, user := range users {
+		secret := user.TotpSecret
+		if helpers.IsSealedSecret(secret) {
+			secret, err = openTotpSecret(t.Keyring, &user)
+			if err != nil {
+				// the key is gone, the user has to use a recovery code
+				continue
+			}
+		}
+
+		sealedSecret, err := helpers.SealSecret(t.Keyring, secret, totpSecretBinding(user.Id))
+		if err != nil {
+			_ = tx.Rollback()
+			return exceptions.NewInternalServerError()
+		}
+
+		err = t.UserRepo.ReplaceTotpSecret(ctx, tx, user.Id, user.TotpSecret, sealedSecret)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	_ = tx.Commit()
+
+	return nil
+}
+
+// reauthenticate asks for the password again, accounts without a password
+// prove themselves by a fresh sign in.
+func (t TwoFactorServiceImpl) reauthenticate(user *model.User, session *model.Session, password string) error {
+	if user.Password != "" {
+		if !t.PasswordHasher.Verify(password, user.Password) {
+			return exceptions.NewUnauthorizedError("Password is incorrect")
+		}
+	} else if time.Since(session.CreatedAt) > t.Cnf.Env.GetDuration("TWO_FACTOR_RECENT_LOGIN") {
+		return exceptions.NewUnauthorizedError("Please sign in again before changing two-factor authentication")
+	}
+
+	return nil
+}
+
+func totpSecretBinding(userId int) string {
+	return "totp_secret:" + strconv.Itoa(userId)
+}
+
+// openTotpSecret decrypts the TOTP secret stored for the user.
+func openTotpSecret(keyring *config.Keyring, user *model.User) (string, error) {
+	secret, err := helpers.OpenSecret(keyring, user.TotpSecret, totpSecretBinding(user.Id))
+	if err != nil {
+		log.Println("error while open totp secret of user", user.Id, err)
+		return "", exceptions.NewInternalServerError()
+	}
+
+	return secret, nil
+}
+
+func generateRecoveryCodes(ctx context.Context, tx *sql.Tx, recoveryCodeRepo repository.RecoveryCodeRepository, userId int) ([]string, error) {
+	err := recoveryCodeRepo.DeleteAllByUserId(ctx, tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := helpers.GenerateRecoveryCode()
+		if err != nil {
+			return nil, exceptions.NewInternalServerError()
+		}
+
+		_, err = recoveryCodeRepo.Save(ctx, tx, &model.RecoveryCode{
+			UserId:    userId,
+			Code:      helpers.HashToken(code),
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		recoveryCodes = append(recoveryCodes, code)
+	}
+
+	return recoveryCodes, nil
+}
+
+// verifySecondFactor accepts either a TOTP code or one of the user's unused
+// recovery codes. A TOTP code can only be used once within its time step.
+// Recovery codes keep working when the secret cannot be decrypted.
+func verifySecondFactor(ctx context.Context, tx *sql.Tx, redisClient *redis.Client, keyring *config.Keyring, recoveryCodeRepo repository.RecoveryCodeRepository, user *model.User, code string) (bool, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	secret, err := openTotpSecret(keyring, user)
+	if err == nil {
+		if step, ok := helpers.VerifyTotp(secret, code, time.Now()); ok {
+			key := fmt.Sprintf("totp_used:%d:%d", user.Id, step)
+			fresh, err := redisClient.SetNX(ctx, key, 1, 2*time.Minute).Result()
+			if err != nil {
+				log.Println("error while set totp step to redis", err)
+				return false, exceptions.NewInternalServerError()
+			}
+
+			return fresh, nil
+		}
+	}
+
+	recoveryCode, err := recoveryCodeRepo.FindUnusedByUserIdAndCode(ctx, tx, user.Id, helpers.HashToken(code))
+	if err != nil && errors.Is(err, exceptions.NotFoundError{}) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return recoveryCodeRepo.MarkUsed(ctx, tx, recoveryCode.Id)
+}